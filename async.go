@@ -0,0 +1,149 @@
+package behaviortree
+
+import (
+  "sync"
+  "errors"
+  "context"
+)
+
+// The work of an AsyncLeafNode returned Running
+var ErrAsyncRunning = errors.New("async work returned Running")
+
+// A leaf node that runs work in its own goroutine
+// The work starts on the first tick after Initiate,
+// and the node is Running without waiting for it until it returns.
+// Messages of every tick are forwarded to the work,
+// and messages it sends are added to those of the next tick.
+// Terminate, including a halt, cancels the context of the task
+type AsyncLeafNode struct {
+  BasicNode
+  asyncMemory
+  work func(task *AsyncTask) Status
+}
+
+// The task of a running AsyncLeafNode
+type asyncMemory struct {
+  task *AsyncTask
+}
+
+// The work of an AsyncLeafNode, as seen from its goroutine
+type AsyncTask struct {
+  // The state of the tick that started the task
+  State interface{}
+  // Done when the node is terminated,
+  // derived from the context of the agent
+  Context context.Context
+
+  cancel func()
+  done chan struct{}
+  status Status
+  panicked interface{}
+
+  mu sync.Mutex
+  inbox []interface{}
+  outbox []interface{}
+}
+
+// The messages the tree sent since the last call
+func (t *AsyncTask) Receive() []interface{} {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  messages := t.inbox
+  t.inbox = nil
+  return messages
+}
+
+// Add messages to those of the next tick of the tree
+func (t *AsyncTask) Send(messages ...interface{}) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  t.outbox = append(t.outbox, messages...)
+}
+
+// Run work and record its status
+// A panic is kept to be raised in the tick
+func (t *AsyncTask) run(work func(task *AsyncTask) Status) {
+  defer close(t.done)
+  defer func() {
+    if r := recover(); r != nil {
+      t.panicked = r
+      t.status = Error
+    }
+  }()
+  t.status = work(t)
+}
+
+// Hand messages to the work and take the ones it sent
+func (t *AsyncTask) exchange(messages []interface{}) []interface{} {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  t.inbox = append(t.inbox, messages...)
+  messages = append(messages, t.outbox...)
+  t.outbox = nil
+  return messages
+}
+
+func (n *AsyncLeafNode) Initiate() {
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *AsyncLeafNode) InitiateAgent(a *Agent) {
+  agentMemory(a, n, &n.asyncMemory).task = nil
+}
+
+func (n *AsyncLeafNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *AsyncLeafNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  m := agentMemory(a, n, &n.asyncMemory)
+  if m.task == nil {
+    t := &AsyncTask{State: state, done: make(chan struct{})}
+    t.Context, t.cancel = context.WithCancel(a.Ctx())
+    m.task = t
+    go t.run(n.work)
+  }
+  t := m.task
+  select {
+  case <-t.done:
+    messages = t.exchange(messages)
+    if t.panicked != nil {
+      panic(t.panicked)
+    }
+    if t.status == Running {
+      a.fail(ErrAsyncRunning, nil)
+      return Error, messages
+    }
+    return t.status, messages
+  default:
+    return Running, t.exchange(messages)
+  }
+}
+
+func (n *AsyncLeafNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *AsyncLeafNode) TerminateAgent(a *Agent) {
+  if t := agentMemory(a, n, &n.asyncMemory).task; t != nil {
+    t.cancel()
+  }
+}
+
+func (n *AsyncLeafNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.asyncMemory = asyncMemory{}
+  return &c
+}
+
+// Create a new async leaf node
+// work should return when the context of the task is done
+// It returns Success, Failure or Error, returning Running
+// ends the tick in Error, with ErrAsyncRunning in the agent
+func NewAsyncLeafNode(work func(task *AsyncTask) Status) *AsyncLeafNode {
+  n := new(AsyncLeafNode)
+  n.work = work
+  return n
+}
@@ -0,0 +1,144 @@
+package behaviortree
+
+import "time"
+
+// Named values, with getters that return def
+// when the value is missing or of another type
+type Memory map[string]interface{}
+
+func (m Memory) Get(key string) interface{} {
+  return m[key]
+}
+
+func (m Memory) Set(key string, value interface{}) {
+  m[key] = value
+}
+
+func (m Memory) Delete(key string) {
+  delete(m, key)
+}
+
+func (m Memory) Int(key string, def int) int {
+  switch v := m[key].(type) {
+  case int:
+    return v
+  case float64:
+    return int(v)
+  default:
+    return def
+  }
+}
+
+func (m Memory) Float(key string, def float64) float64 {
+  switch v := m[key].(type) {
+  case float64:
+    return v
+  case int:
+    return float64(v)
+  default:
+    return def
+  }
+}
+
+func (m Memory) String(key string, def string) string {
+  if v, ok := m[key].(string); ok {
+    return v
+  }
+  return def
+}
+
+func (m Memory) Bool(key string, def bool) bool {
+  if v, ok := m[key].(bool); ok {
+    return v
+  }
+  return def
+}
+
+func (m Memory) Status(key string, def Status) Status {
+  if v, ok := m[key].(Status); ok {
+    return v
+  }
+  return def
+}
+
+func (m Memory) Duration(key string, def time.Duration) time.Duration {
+  if v, ok := m[key].(time.Duration); ok {
+    return v
+  }
+  return def
+}
+
+// The memory of one agent, in three scopes:
+// global, per tree, and per node of a tree
+// Trees are identified by any comparable key, usually the root node
+// A blackboard is not safe to use from multiple goroutines
+type Blackboard struct {
+  global Memory
+  trees map[interface{}]*treeMemory
+}
+
+type treeMemory struct {
+  memory Memory
+  nodes map[Node]*nodeMemory
+}
+
+// What the blackboard keeps for a single node
+type nodeMemory struct {
+  memory Memory
+  nodeState
+}
+
+// Status and runtime memory of a node ticked by an Agent
+type nodeState struct {
+  status Status
+  state interface{}
+}
+
+func NewBlackboard() *Blackboard {
+  b := new(Blackboard)
+  b.global = make(Memory)
+  b.trees = make(map[interface{}]*treeMemory)
+  return b
+}
+
+// Memory shared by all trees
+func (b *Blackboard) Global() Memory {
+  return b.global
+}
+
+// Memory of a single tree
+func (b *Blackboard) Tree(tree interface{}) Memory {
+  t := b.tree(tree)
+  if t.memory == nil {
+    t.memory = make(Memory)
+  }
+  return t.memory
+}
+
+// Memory of a single node in a tree
+func (b *Blackboard) Node(tree interface{}, node Node) Memory {
+  n := b.node(tree, node)
+  if n.memory == nil {
+    n.memory = make(Memory)
+  }
+  return n.memory
+}
+
+func (b *Blackboard) tree(tree interface{}) *treeMemory {
+  t, ok := b.trees[tree]
+  if !ok {
+    t = &treeMemory{nodes: make(map[Node]*nodeMemory)}
+    b.trees[tree] = t
+  }
+  return t
+}
+
+func (b *Blackboard) node(tree interface{}, node Node) *nodeMemory {
+  t := b.tree(tree)
+  n, ok := t.nodes[node]
+  if !ok {
+    n = new(nodeMemory)
+    t.nodes[node] = n
+  }
+  return n
+}
@@ -0,0 +1,60 @@
+package behaviortree
+
+import (
+  "sync"
+  "time"
+)
+
+// Tells time-based nodes like TimeoutNode what time it is
+type Clock interface {
+  Now() time.Time
+}
+
+// The wall clock
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+  return time.Now()
+}
+
+// A clock that only moves when told to,
+// for simulations and tests
+// It is safe to use from multiple goroutines
+type ManualClock struct {
+  mu sync.Mutex
+  now time.Time
+}
+
+// Create a manual clock that starts at start
+func NewManualClock(start time.Time) *ManualClock {
+  return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.now
+}
+
+// Move the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.now = c.now.Add(d)
+}
+
+// Move the clock to t
+func (c *ManualClock) Set(t time.Time) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.now = t
+}
+
+// The time according to the clock of the agent,
+// or the wall clock if it has none
+func (a *Agent) Now() time.Time {
+  if a.Clock == nil {
+    return time.Now()
+  }
+  return a.Clock.Now()
+}
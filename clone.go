@@ -0,0 +1,56 @@
+package behaviortree
+
+import "reflect"
+
+// Implemented by nodes that can copy themselves and their descendants
+// The copy should not share any runtime state with the original
+type Cloner interface {
+  Clone() Node
+}
+
+// Create an independent copy of the tree below node
+// Built-in nodes are copied as they were before their first tick.
+// Nodes that do not implement Cloner, or only through an embedded node,
+// are copied field by field, cloning the children
+// of an embedded CompositeNode or Decorator
+func CloneTree(node Node) Node {
+  switch n := node.(type) {
+  case nil:
+    return nil
+  case BasicNode:
+    return n
+  case *BasicNode:
+    c := *n
+    return &c
+  }
+  if c, ok := node.(Cloner); ok {
+    if clone := c.Clone(); reflect.TypeOf(clone) == reflect.TypeOf(node) {
+      return clone
+    }
+  }
+  v := reflect.ValueOf(node)
+  if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+    return node
+  }
+  c := reflect.New(v.Elem().Type())
+  c.Elem().Set(v.Elem())
+  clone := c.Interface().(Node)
+  if p, ok := clone.(interface{ cloneChildren() }); ok {
+    p.cloneChildren()
+  }
+  return clone
+}
+
+// Replace the children with clones
+func (n *CompositeNode) cloneChildren() {
+  children := make([]Node, len(n.Children))
+  for idx, child := range n.Children {
+    children[idx] = CloneTree(child)
+  }
+  n.Children = children
+}
+
+// Replace the child with a clone
+func (n *Decorator) cloneChildren() {
+  n.Child = CloneTree(n.Child)
+}
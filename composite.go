@@ -1,21 +1,39 @@
 package behaviortree
 
+import (
+  "fmt"
+  "sort"
+)
+
 type CompositeNode struct {
   BasicNode
   Children []Node
 }
 
 func (n *CompositeNode) Terminate() {
-  for _, child := range n.Children {
-    child.Terminate()
+  n.TerminateAgent(new(Agent))
+}
+
+// Halt the children that are still running
+func (n *CompositeNode) TerminateAgent(a *Agent) {
+  n.haltFrom(a, 0)
+}
+
+// Halt the running children from index on
+func (n *CompositeNode) haltFrom(a *Agent, index int) {
+  for ; index < len(n.Children); index++ {
+    a.Halt(n.Children[index])
   }
 }
 
 // Generic function for (memory) sequential an selector nodes
-func compositeUpdate(n *CompositeNode, state interface{}, messages []interface{}, currentIndex int, endCondition Status) (Status, []interface{}, int) {
+func compositeUpdate(a *Agent, n *CompositeNode, state interface{}, messages []interface{}, currentIndex int, endCondition Status) (Status, []interface{}, int) {
   for ; currentIndex<len(n.Children); currentIndex++ {
+    if a.cancelled() {
+      return a.cancelledStatus(), messages, currentIndex
+    }
     var status Status
-    status, messages = Tick(n.Children[currentIndex], state, messages)
+    status, messages = a.Tick(n.Children[currentIndex], state, messages)
     if status == endCondition {
       continue
     } else {
@@ -26,15 +44,34 @@ func compositeUpdate(n *CompositeNode, state interface{}, messages []interface{}
 }
 
 // A node that finds the first successfull child
+// Children are evaluated from the first on every tick,
+// a running child that is no longer reached is halted
 type SelectorNode struct {
   CompositeNode
 }
 
 func (n *SelectorNode) Update(state interface{}, messages []interface{}) []interface{} {
-  n.Status, messages, _ = compositeUpdate(&n.CompositeNode, state, messages, 0, Failure)
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
   return messages
 }
 
+func (n *SelectorNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  status, messages, index := compositeUpdate(a, &n.CompositeNode, state, messages, 0, Failure)
+  n.haltFrom(a, index+1)
+  return status, messages
+}
+
+func (n *SelectorNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("Priority", nil, n.Children)
+}
+
+func (n *SelectorNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.cloneChildren()
+  return &c
+}
+
 // Create a new selector node with the given children
 func NewSelectorNode(children[]Node) *SelectorNode{
   n := new(SelectorNode)
@@ -42,16 +79,138 @@ func NewSelectorNode(children[]Node) *SelectorNode{
   return n
 }
 
+// Implemented by nodes that know how useful they are in a state,
+// for UtilitySelectorNode
+type Scorer interface {
+  Score(state interface{}) float64
+}
+
+// Scores a child of a UtilitySelectorNode
+type ScoreFunc func(state interface{}) float64
+
+// A node that ticks the child with the highest score
+// The score of a child comes from its function in Scores,
+// from the child if it is a Scorer, or is zero
+// The child chosen last gets Hysteresis added to its score,
+// so others only take over when they are clearly better
+// With FallThrough, a failing child is followed by the next best
+// Children that are not chosen are halted
+type UtilitySelectorNode struct {
+  CompositeNode
+  Scores []ScoreFunc
+  // The names the Scores were registered with, for encoding
+  ScoreNames []string
+  Hysteresis float64
+  FallThrough bool
+  chosen Node
+}
+
+func (n *UtilitySelectorNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *UtilitySelectorNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  chosen := agentMemory(a, n, &n.chosen)
+  for _, index := range n.order(state, *chosen) {
+    if a.cancelled() {
+      return a.cancelledStatus(), messages
+    }
+    var status Status
+    status, messages = a.Tick(n.Children[index], state, messages)
+    if status == Failure && n.FallThrough {
+      continue
+    }
+    *chosen = n.Children[index]
+    for i, child := range n.Children {
+      if i != index {
+        a.Halt(child)
+      }
+    }
+    return status, messages
+  }
+  return Failure, messages
+}
+
+// The indexes of the children, best score first
+func (n *UtilitySelectorNode) order(state interface{}, chosen Node) []int {
+  scores := make([]float64, len(n.Children))
+  order := make([]int, len(n.Children))
+  for idx, child := range n.Children {
+    order[idx] = idx
+    if idx < len(n.Scores) && n.Scores[idx] != nil {
+      scores[idx] = n.Scores[idx](state)
+    } else if s, ok := child.(Scorer); ok {
+      scores[idx] = s.Score(state)
+    }
+    if child == chosen {
+      scores[idx] += n.Hysteresis
+    }
+  }
+  sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+  return order
+}
+
+// Score functions are written by their names
+func (n *UtilitySelectorNode) Encode(e *Encoder) (ProjectNode, error) {
+  for idx, fn := range n.Scores {
+    if fn != nil && (idx >= len(n.ScoreNames) || n.ScoreNames[idx] == "") {
+      return ProjectNode{}, fmt.Errorf("%w: score function %d without a name", ErrNotEncodable, idx)
+    }
+  }
+  props := map[string]interface{}{"hysteresis": n.Hysteresis, "fallThrough": n.FallThrough}
+  if len(n.ScoreNames) > 0 {
+    props["scores"] = n.ScoreNames
+  }
+  return e.Composite("UtilitySelector", props, n.Children)
+}
+
+func (n *UtilitySelectorNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.chosen = nil
+  c.cloneChildren()
+  return &c
+}
+
+// Create a new utility selector node
+// with an optional score function for each of the children
+func NewUtilitySelectorNode(scores []ScoreFunc, children[]Node) *UtilitySelectorNode{
+  n := new(UtilitySelectorNode)
+  n.Children = children
+  n.Scores = scores
+  return n
+}
+
 // A node that runs all childs until one fails
+// Children are evaluated from the first on every tick,
+// a running child that is no longer reached is halted
 type SequentialNode struct {
   CompositeNode
 }
 
 func (n *SequentialNode) Update(state interface{}, messages []interface{}) []interface{} {
-  n.Status, messages, _ = compositeUpdate(&n.CompositeNode, state, messages, 0, Success)
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
   return messages
 }
 
+func (n *SequentialNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  status, messages, index := compositeUpdate(a, &n.CompositeNode, state, messages, 0, Success)
+  n.haltFrom(a, index+1)
+  return status, messages
+}
+
+func (n *SequentialNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("Sequence", nil, n.Children)
+}
+
+func (n *SequentialNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.cloneChildren()
+  return &c
+}
+
 // Create a new sequential node with the given children
 func NewSequentialNode(children[]Node) *SequentialNode{
   n := new(SequentialNode)
@@ -59,9 +218,93 @@ func NewSequentialNode(children[]Node) *SequentialNode{
   return n
 }
 
+// Generic function for reactive sequence and fallback nodes
+// Every child but the one that ended the evaluation is halted
+func reactiveUpdate(a *Agent, n *CompositeNode, state interface{}, messages []interface{}, endCondition Status) (Status, []interface{}) {
+  status, messages, index := compositeUpdate(a, n, state, messages, 0, endCondition)
+  for i, child := range n.Children {
+    if i != index {
+      a.Halt(child)
+    }
+  }
+  return status, messages
+}
+
+// A sequence that checks all its children again on every tick
+// When an earlier child fails or starts running,
+// the child that was running is preempted and halted
+// Like the ReactiveSequence of BehaviorTree.CPP
+type ReactiveSequenceNode struct {
+  CompositeNode
+}
+
+func (n *ReactiveSequenceNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *ReactiveSequenceNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  return reactiveUpdate(a, &n.CompositeNode, state, messages, Success)
+}
+
+func (n *ReactiveSequenceNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("ReactiveSequence", nil, n.Children)
+}
+
+func (n *ReactiveSequenceNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.cloneChildren()
+  return &c
+}
+
+// Create a new reactive sequence node with the given children
+func NewReactiveSequenceNode(children[]Node) *ReactiveSequenceNode{
+  n := new(ReactiveSequenceNode)
+  n.Children = children
+  return n
+}
+
+// A selector that checks all its children again on every tick
+// When an earlier child succeeds or starts running,
+// the child that was running is preempted and halted
+// Like the ReactiveFallback of BehaviorTree.CPP
+type ReactiveFallbackNode struct {
+  CompositeNode
+}
+
+func (n *ReactiveFallbackNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *ReactiveFallbackNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  return reactiveUpdate(a, &n.CompositeNode, state, messages, Failure)
+}
+
+func (n *ReactiveFallbackNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("ReactiveFallback", nil, n.Children)
+}
+
+func (n *ReactiveFallbackNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.cloneChildren()
+  return &c
+}
+
+// Create a new reactive fallback node with the given children
+func NewReactiveFallbackNode(children[]Node) *ReactiveFallbackNode{
+  n := new(ReactiveFallbackNode)
+  n.Children = children
+  return n
+}
+
 // A node that runs all children
 // Success or Failure is defined by
 // the number of children that fail or succeed
+// Children still running at that point are halted
+// A child that returns Error stops the node with Error
 type ParallelNode struct {
   CompositeNode
   MinimumSuccesses int
@@ -69,25 +312,62 @@ type ParallelNode struct {
 }
 
 func (n *ParallelNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *ParallelNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
   totalFailures := 0
   totalSuccesses := 0
   for _, child := range n.Children {
+    if a.cancelled() {
+      return a.cancelledStatus(), messages
+    }
     var status Status
-    status, messages = Tick(child, state, messages)
+    status, messages = a.Tick(child, state, messages)
     if status == Success {
       totalSuccesses++
     } else if status == Failure {
       totalFailures++
+    } else if status == Error {
+      n.haltFrom(a, 0)
+      return Error, messages
     }
   }
+  status := n.result(totalSuccesses, totalFailures)
+  if status != Running {
+    n.haltFrom(a, 0)
+  }
+  return status, messages
+}
+
+// The status for the given number of finished children
+func (n *ParallelNode) result(totalSuccesses int, totalFailures int) Status {
   if totalSuccesses >= n.MinimumSuccesses {
-    n.Status = Success;
+    return Success
   } else if totalFailures >= n.MinimumFailures {
-    n.Status = Failure;
+    return Failure
   } else {
-    n.Status = Running
+    return Running
+  }
+}
+
+func (n *ParallelNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("ParallelSequence", n.properties(), n.Children)
+}
+
+func (n *ParallelNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.cloneChildren()
+  return &c
+}
+
+func (n *ParallelNode) properties() map[string]interface{} {
+  return map[string]interface{}{
+    "minSuccess": n.MinimumSuccesses,
+    "minFail": n.MinimumFailures,
   }
-  return messages
 }
 
 // Create a new parallel node with the given children
@@ -124,42 +404,76 @@ func NewParallelNodeAll(successOnAll bool, failOnAll bool, children[]Node) *Para
 // the number of children that fail or succeed
 type ParallelMemoryNode struct {
   ParallelNode
+  ParallelMemory
+}
+
+// What a ParallelMemoryNode remembers between ticks
+type ParallelMemory struct {
   Completed []bool
   TotalFailures int
   TotalSuccesses int
 }
 
 func (n *ParallelMemoryNode) Initiate() {
-  for i := range n.Completed {
-    n.Completed[i] = false
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *ParallelMemoryNode) InitiateAgent(a *Agent) {
+  m := agentMemory(a, n, &n.ParallelMemory)
+  if len(m.Completed) != len(n.Children) {
+    m.Completed = make([]bool, len(n.Children))
+  }
+  for i := range m.Completed {
+    m.Completed[i] = false
   }
-  n.TotalFailures = 0
-  n.TotalSuccesses = 0
+  m.TotalFailures = 0
+  m.TotalSuccesses = 0
 }
 
 func (n *ParallelMemoryNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *ParallelMemoryNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  m := agentMemory(a, n, &n.ParallelMemory)
   for i, child := range n.Children {
-    if !n.Completed[i] {
+    if a.cancelled() {
+      return a.cancelledStatus(), messages
+    }
+    if !m.Completed[i] {
       var status Status
-      status, messages = Tick(child, state, messages)
+      status, messages = a.Tick(child, state, messages)
       if status != Running {
-        n.Completed[i] = true
+        m.Completed[i] = true
       }
       if status == Success {
-        n.TotalSuccesses++
+        m.TotalSuccesses++
       } else if status == Failure {
-        n.TotalFailures++
+        m.TotalFailures++
+      } else if status == Error {
+        n.haltFrom(a, 0)
+        return Error, messages
       }
     }
   }
-  if n.TotalSuccesses >= n.MinimumSuccesses {
-    n.Status = Success;
-  } else if n.TotalFailures >= n.MinimumFailures {
-    n.Status = Failure;
-  } else {
-    n.Status = Running
+  status := n.result(m.TotalSuccesses, m.TotalFailures)
+  if status != Running {
+    n.haltFrom(a, 0)
   }
-  return messages
+  return status, messages
+}
+
+func (n *ParallelMemoryNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("ParallelTactic", n.properties(), n.Children)
+}
+
+func (n *ParallelMemoryNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.ParallelMemory = ParallelMemory{Completed: make([]bool, len(n.Children))}
+  c.cloneChildren()
+  return &c
 }
 
 // Create a new parallel node with the given children
@@ -191,6 +505,7 @@ func NewParallelMemoryNodeAll(successOnAll bool, failOnAll bool, children[]Node)
   }
   return n
 }
+
 type MemoryNode struct {
   CurrentIndex int
 }
@@ -205,13 +520,36 @@ type SequentialMemoryNode struct {
   MemoryNode
 }
 
+func (n *SequentialMemoryNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.CurrentIndex) = 0
+}
+
 func (n *SequentialMemoryNode) Update(state interface{}, messages []interface{}) []interface{} {
-  n.Status, messages, n.CurrentIndex = compositeUpdate(
-    &n.CompositeNode, state, messages, n.CurrentIndex, Success,
-  )
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
   return messages
 }
 
+func (n *SequentialMemoryNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  var status Status
+  index := agentMemory(a, n, &n.CurrentIndex)
+  status, messages, *index = compositeUpdate(
+    a, &n.CompositeNode, state, messages, *index, Success,
+  )
+  return status, messages
+}
+
+func (n *SequentialMemoryNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("MemSequence", nil, n.Children)
+}
+
+func (n *SequentialMemoryNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.CurrentIndex = 0
+  c.cloneChildren()
+  return &c
+}
+
 // Create a new sequential memory node with the given children
 func NewSequentialMemoryNode(children[]Node) *SequentialMemoryNode{
   n := new(SequentialMemoryNode)
@@ -225,13 +563,36 @@ type SelectorMemoryNode struct {
   MemoryNode
 }
 
+func (n *SelectorMemoryNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.CurrentIndex) = 0
+}
+
 func (n *SelectorMemoryNode) Update(state interface{}, messages []interface{}) []interface{} {
-  n.Status, messages, n.CurrentIndex = compositeUpdate(
-    &n.CompositeNode, state, messages, n.CurrentIndex, Failure,
-  )
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
   return messages
 }
 
+func (n *SelectorMemoryNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  var status Status
+  index := agentMemory(a, n, &n.CurrentIndex)
+  status, messages, *index = compositeUpdate(
+    a, &n.CompositeNode, state, messages, *index, Failure,
+  )
+  return status, messages
+}
+
+func (n *SelectorMemoryNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("MemPriority", nil, n.Children)
+}
+
+func (n *SelectorMemoryNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.CurrentIndex = 0
+  c.cloneChildren()
+  return &c
+}
+
 // Create a new selector node with the given children
 func NewSelectorMemoryNode(children[]Node) *SelectorMemoryNode{
   n := new(SelectorMemoryNode)
@@ -239,3 +600,186 @@ func NewSelectorMemoryNode(children[]Node) *SelectorMemoryNode{
   return n
 }
 
+// What the random composites remember between ticks
+type RandomMemory struct {
+  // The children in the order they are tried
+  Order []int
+  // The position in Order
+  CurrentIndex int
+}
+
+// Generic function for random and weighted nodes
+// Tries the children in the order of m, resuming where it was
+func randomUpdate(a *Agent, n *CompositeNode, m *RandomMemory, state interface{}, messages []interface{}, endCondition Status) (Status, []interface{}) {
+  for ; m.CurrentIndex < len(m.Order); m.CurrentIndex++ {
+    if a.cancelled() {
+      return a.cancelledStatus(), messages
+    }
+    var status Status
+    status, messages = a.Tick(n.Children[m.Order[m.CurrentIndex]], state, messages)
+    if status != endCondition {
+      return status, messages
+    }
+  }
+  return endCondition, messages
+}
+
+// Like SelectorMemoryNode, but tries the children
+// in a new random order every time it starts
+// The order comes from the random source of the agent
+type RandomSelectorNode struct {
+  CompositeNode
+  RandomMemory
+}
+
+func (n *RandomSelectorNode) Initiate() {
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *RandomSelectorNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.RandomMemory) = RandomMemory{Order: a.perm(len(n.Children))}
+}
+
+func (n *RandomSelectorNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *RandomSelectorNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  return randomUpdate(a, &n.CompositeNode, agentMemory(a, n, &n.RandomMemory), state, messages, Failure)
+}
+
+func (n *RandomSelectorNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("RandomSelector", nil, n.Children)
+}
+
+func (n *RandomSelectorNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.RandomMemory = RandomMemory{}
+  c.cloneChildren()
+  return &c
+}
+
+// Create a new random selector node with the given children
+func NewRandomSelectorNode(children[]Node) *RandomSelectorNode{
+  n := new(RandomSelectorNode)
+  n.Children = children
+  return n
+}
+
+// Like SequentialMemoryNode, but runs the children
+// in a new random order every time it starts
+// The order comes from the random source of the agent
+type RandomSequenceNode struct {
+  CompositeNode
+  RandomMemory
+}
+
+func (n *RandomSequenceNode) Initiate() {
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *RandomSequenceNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.RandomMemory) = RandomMemory{Order: a.perm(len(n.Children))}
+}
+
+func (n *RandomSequenceNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *RandomSequenceNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  return randomUpdate(a, &n.CompositeNode, agentMemory(a, n, &n.RandomMemory), state, messages, Success)
+}
+
+func (n *RandomSequenceNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("RandomSequence", nil, n.Children)
+}
+
+func (n *RandomSequenceNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.RandomMemory = RandomMemory{}
+  c.cloneChildren()
+  return &c
+}
+
+// Create a new random sequence node with the given children
+func NewRandomSequenceNode(children[]Node) *RandomSequenceNode{
+  n := new(RandomSequenceNode)
+  n.Children = children
+  return n
+}
+
+// Like RandomSelectorNode, but children with a higher weight
+// are more likely to be tried first
+// Children without a positive weight are never tried
+type WeightedSelectorNode struct {
+  CompositeNode
+  RandomMemory
+  Weights []float64
+}
+
+func (n *WeightedSelectorNode) Initiate() {
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *WeightedSelectorNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.RandomMemory) = RandomMemory{Order: n.order(a)}
+}
+
+// Draw the children one by one, by weight
+func (n *WeightedSelectorNode) order(a *Agent) []int {
+  var order []int
+  var total float64
+  for idx, w := range n.Weights {
+    if w > 0 && idx < len(n.Children) {
+      order = append(order, idx)
+      total += w
+    }
+  }
+  for i := range order {
+    r := a.float64() * total
+    j := i
+    for ; j < len(order)-1; j++ {
+      r -= n.Weights[order[j]]
+      if r < 0 {
+        break
+      }
+    }
+    order[i], order[j] = order[j], order[i]
+    total -= n.Weights[order[i]]
+  }
+  return order
+}
+
+func (n *WeightedSelectorNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *WeightedSelectorNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  return randomUpdate(a, &n.CompositeNode, agentMemory(a, n, &n.RandomMemory), state, messages, Failure)
+}
+
+func (n *WeightedSelectorNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Composite("WeightedSelector", map[string]interface{}{"weights": n.Weights}, n.Children)
+}
+
+func (n *WeightedSelectorNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.RandomMemory = RandomMemory{}
+  c.cloneChildren()
+  return &c
+}
+
+// Create a new weighted selector node
+// with a weight for each of the children
+func NewWeightedSelectorNode(weights []float64, children[]Node) *WeightedSelectorNode{
+  n := new(WeightedSelectorNode)
+  n.Children = children
+  n.Weights = weights
+  return n
+}
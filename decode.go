@@ -2,179 +2,279 @@ package behaviortree
 
 import (
   "fmt"
-  "time"
-  "io"
-  "encoding/json"
+  "errors"
+  "strings"
 )
 
-type Project struct {
-  Name string
-  Data struct {
-    Trees []struct {
-      Title string
-      Root string
-      Nodes map[string] ProjectNode
-    }
-  }
-}
+// Builds a node from its project description
+// b is used to build the children of root
+type NodeConstructor func(b *Builder, root ProjectNode) (Node, error)
 
-type ProjectNode struct {
+var (
+  // A node name has no registered constructor
+  ErrUnknownNode = errors.New("no constructor for node")
+  // A node refers to a node id that is not in the tree
+  ErrMissingNode = errors.New("reference to missing node")
+  // A tree includes itself through its subtrees
+  ErrSubtreeCycle = errors.New("recursive subtree")
+  // A constructor returned neither a node nor an error
+  ErrNilNode = errors.New("constructor returned no node")
+)
+
+// A problem with a single node of a project
+// Ref is the referenced node id when the reference is at fault
+type NodeError struct {
+  Tree string
   Id string
   Name string
-  Title string
-  Properties map[string]interface{}
-  Child string
-  Children []string
+  Ref string
+  Err error
 }
 
-var NodeTypeRegister = make(map[string]func(ProjectNode, map[string]ProjectNode)Node)
-
-func ReadProject(file io.Reader) (*Project, error) {
-  var pr Project
-  dec := json.NewDecoder(file)
-  err := dec.Decode(&pr)
-  if err != nil {
-    return nil, err
-  } else {
-    return &pr, nil
+func (e *NodeError) Error() string {
+  s := fmt.Sprintf("node %q (%s)", e.Id, e.Name)
+  if e.Tree != "" {
+    s = fmt.Sprintf("tree %q: %s", e.Tree, s)
+  }
+  if e.Ref != "" || errors.Is(e.Err, ErrMissingNode) {
+    s += fmt.Sprintf(": ref %q", e.Ref)
   }
+  return s + ": " + e.Err.Error()
 }
 
-// classical C-style output argument
-// -.-
-func MakeTrees(pr *Project, trees map[string]Node) {
-  for _, tree := range pr.Data.Trees {
-    node, _ := MakeNode(tree.Root, tree.Nodes)
-    trees[tree.Title] = node
-  }
+func (e *NodeError) Unwrap() error {
+  return e.Err
 }
 
-func MakeNode(root string, nodes map[string]ProjectNode) (Node, bool) {
-  node := nodes[root]
-  fn, ok := NodeTypeRegister[node.Name]
-  if ok {
-    return fn(node, nodes), true
-  } else {
-    fmt.Printf("No constructor for %s\n", node.Name)
-    return nil, false //NewConstantNode(Failure), false
+// All problems found while building trees
+// so a broken project can be fixed in one go
+type ErrorList []*NodeError
+
+func (l ErrorList) Error() string {
+  msgs := make([]string, len(l))
+  for idx, err := range l {
+    msgs[idx] = err.Error()
   }
+  return strings.Join(msgs, "\n")
 }
 
-func init() {
-  // Composite nodes
-  NodeTypeRegister["Priority"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    children := make([]Node, len(root.Children))
-    for idx, child := range root.Children {
-      children[idx], _ = MakeNode(child, nodes)
-    }
-    return NewSelectorNode(children)
+// Allows errors.Is and errors.As to look at every problem
+func (l ErrorList) Unwrap() []error {
+  errs := make([]error, len(l))
+  for idx, err := range l {
+    errs[idx] = err
   }
+  return errs
+}
 
-  NodeTypeRegister["MemPriority"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    children := make([]Node, len(root.Children))
-    for idx, child := range root.Children {
-      children[idx], _ = MakeNode(child, nodes)
-    }
-    return NewSelectorMemoryNode(children)
+// Add err to the list, attributing plain errors to node
+// Problems already in the list are not added again
+func (l ErrorList) add(node ProjectNode, err error) ErrorList {
+  var list ErrorList
+  var nerr *NodeError
+  switch {
+  case err == nil:
+    return l
+  case errors.As(err, &list):
+  case errors.As(err, &nerr):
+    list = ErrorList{nerr}
+  default:
+    list = ErrorList{{Id: node.Id, Name: node.Name, Err: err}}
   }
-
-  NodeTypeRegister["Sequence"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    children := make([]Node, len(root.Children))
-    for idx, child := range root.Children {
-      children[idx], _ = MakeNode(child, nodes)
+  for _, nerr := range list {
+    if !l.contains(nerr) {
+      l = append(l, nerr)
     }
-    return NewSequentialNode(children)
   }
+  return l
+}
 
-  NodeTypeRegister["MemSequence"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    children := make([]Node, len(root.Children))
-    for idx, child := range root.Children {
-      children[idx], _ = MakeNode(child, nodes)
+func (l ErrorList) contains(err *NodeError) bool {
+  for _, e := range l {
+    if e == err {
+      return true
     }
-    return NewSequentialMemoryNode(children)
   }
+  return false
+}
 
-  NodeTypeRegister["ParallelSequence"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    children := make([]Node, len(root.Children))
-    for idx, child := range root.Children {
-      children[idx], _ = MakeNode(child, nodes)
-    }
-    succ, ok1 := root.Properties["minSuccess"]
-    fail, ok2 := root.Properties["minFail"]
-    if ok1 && ok2 {
-      minSuccess := int(succ.(float64))
-      minFail    := int(fail.(float64))
-      return NewParallelNodeBounded(minSuccess, minFail, children)
-    } else {
-      return NewParallelNodeAll(true, false, children)
-    }
+// Return the list as an error, or nil if it is empty
+func (l ErrorList) err() error {
+  if len(l) == 0 {
+    return nil
   }
+  return l
+}
 
-  NodeTypeRegister["ParallelTactic"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    children := make([]Node, len(root.Children))
-    for idx, child := range root.Children {
-      children[idx], _ = MakeNode(child, nodes)
-    }
-    succ, ok1 := root.Properties["minSuccess"]
-    fail, ok2 := root.Properties["minFail"]
-    if ok1 && ok2 {
-      minSuccess := int(succ.(float64))
-      minFail    := int(fail.(float64))
-      return NewParallelMemoryNodeBounded(minSuccess, minFail, children)
-    } else {
-      return NewParallelMemoryNodeAll(true, false, children)
+// classical C-style output argument
+// -.-
+// Trees that fail to build are left out
+// and all their problems are returned as an ErrorList
+// Nodes are looked up in the registry that Register adds to
+func MakeTrees(pr *Project, trees map[string]Node) error {
+  return globalRegistry.MakeTrees(pr, trees)
+}
+
+// Build the node with id root and all its descendants
+// Building continues past broken nodes
+// so the returned ErrorList names every problem
+// Nodes are looked up in the registry that Register adds to
+func MakeNode(root string, nodes map[string]ProjectNode) (Node, error) {
+  return globalRegistry.MakeNode(root, nodes)
+}
+
+// Holds what is needed to build the nodes of one tree
+// It is handed to every NodeConstructor
+//
+// When Project is set, a node whose name is the id or title
+// of another tree in the project is replaced by that tree.
+// Each reference gets a fresh copy of the tree,
+// unless SharedSubtrees is set, in which case every
+// reference and MakeTrees itself use a single instance
+type Builder struct {
+  Registry *Registry
+  Project *Project
+  SharedSubtrees bool
+  // Title of the tree being built, used in errors
+  Tree string
+  Nodes map[string]ProjectNode
+
+  // Trees being built, outermost first
+  building []*ProjectTree
+  // Trees already built when sharing subtrees
+  shared map[*ProjectTree]Node
+  // Trees that failed to build, so their problems are listed once
+  failed map[*ProjectTree]error
+}
+
+// Build every tree in Project into trees, keyed by title
+// Trees that fail to build are left out
+// and all their problems are returned as an ErrorList
+func (b *Builder) MakeTrees(trees map[string]Node) error {
+  var errs ErrorList
+  for idx := range b.Project.Data.Trees {
+    tree := &b.Project.Data.Trees[idx]
+    node, err := b.makeTree(tree)
+    if err != nil {
+      errs = errs.add(ProjectNode{}, err)
+      continue
     }
+    trees[tree.Title] = node
   }
+  return errs.err()
+}
 
-  // Decorator nodes
-  NodeTypeRegister["Inverter"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    child, _ := MakeNode(root.Child, nodes)
-    return NewInverterNode(child)
+// Build a tree of Project with a builder of its own
+func (b *Builder) makeTree(tree *ProjectTree) (Node, error) {
+  if node, ok := b.shared[tree]; ok {
+    return node, nil
   }
-
-  NodeTypeRegister["FailerDec"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    child, _ := MakeNode(root.Child, nodes)
-    return NewWrapConstantNode(Failure, child)
+  if err, ok := b.failed[tree]; ok {
+    return nil, err
   }
-
-  NodeTypeRegister["SucceederDec"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    child, _ := MakeNode(root.Child, nodes)
-    return NewWrapConstantNode(Success, child)
+  if b.shared == nil {
+    b.shared = make(map[*ProjectTree]Node)
+    b.failed = make(map[*ProjectTree]error)
   }
+  sub := *b
+  sub.Tree = tree.Title
+  sub.Nodes = tree.Nodes
+  sub.building = append(b.building[:len(b.building):len(b.building)], tree)
+  node, err := sub.MakeNode(tree.Root)
+  if err != nil {
+    sub.failed[tree] = err
+  } else if b.SharedSubtrees {
+    sub.shared[tree] = node
+  }
+  return node, err
+}
 
-  NodeTypeRegister["Repeat"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    child, _ := MakeNode(root.Child, nodes)
-    limit := -1
-    if limitProp, ok := root.Properties["limit"]; ok {
-      limitFloat, _ := limitProp.(float64)
-      limit = int(limitFloat)
+// Find the tree of Project with the given id or title
+func (b *Builder) findTree(name string) *ProjectTree {
+  if b.Project == nil {
+    return nil
+  }
+  for idx := range b.Project.Data.Trees {
+    tree := &b.Project.Data.Trees[idx]
+    if tree.Id == name || tree.Title == name {
+      return tree
     }
-    return NewRepeaterNode(limit, child)
   }
+  return nil
+}
 
-  NodeTypeRegister["RepeatUntilSuccess"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    child, _ := MakeNode(root.Child, nodes)
-    return NewRepeatUntilNode(Success, child)
+// Build the node with the given id and all its descendants
+func (b *Builder) MakeNode(id string) (Node, error) {
+  node, ok := b.Nodes[id]
+  if !ok {
+    return nil, b.stamp(ErrorList{{Ref: id, Err: ErrMissingNode}})
   }
-
-  NodeTypeRegister["RepeatUntilFailure"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    child, _ := MakeNode(root.Child, nodes)
-    return NewRepeatUntilNode(Failure, child)
+  fn, ok := b.Registry.Lookup(node.Name)
+  if !ok {
+    if tree := b.findTree(node.Name); tree != nil {
+      return b.makeSubtree(node, tree)
+    }
+    return nil, b.stamp(ErrorList{{Id: node.Id, Name: node.Name, Err: ErrUnknownNode}})
+  }
+  n, err := fn(b, node)
+  if err == nil && n == nil {
+    err = ErrNilNode
   }
+  if errs := ErrorList(nil).add(node, err); len(errs) > 0 {
+    return nil, b.stamp(errs)
+  }
+  if n, ok := n.(interface{ SetId(string) }); ok {
+    n.SetId(node.Id)
+  }
+  return n, nil
+}
 
-  // Utility nodes
-  NodeTypeRegister["Failer"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    return NewConstantNode(Failure)
+// Build the tree that node refers to
+func (b *Builder) makeSubtree(node ProjectNode, tree *ProjectTree) (Node, error) {
+  for idx, outer := range b.building {
+    if outer == tree {
+      chain := make([]string, 0, len(b.building)-idx+1)
+      for _, t := range b.building[idx:] {
+        chain = append(chain, t.Title)
+      }
+      chain = append(chain, tree.Title)
+      err := fmt.Errorf("%w: %s", ErrSubtreeCycle, strings.Join(chain, " -> "))
+      return nil, b.stamp(ErrorList{{Id: node.Id, Name: node.Name, Ref: node.Name, Err: err}})
+    }
   }
+  return b.makeTree(tree)
+}
 
-  NodeTypeRegister["Succeeder"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    return NewConstantNode(Success)
+// Attribute errors without a tree to the tree being built
+func (b *Builder) stamp(errs ErrorList) ErrorList {
+  for _, err := range errs {
+    if err.Tree == "" {
+      err.Tree = b.Tree
+    }
   }
+  return errs
+}
 
-  NodeTypeRegister["Sleep"] = func(root ProjectNode, nodes map[string]ProjectNode)Node {
-    fmt.Println(root.Properties)
-    ms := time.Duration(root.Properties["ms"].(float64))*time.Millisecond
-    return NewTimeoutNode(ms, Success, NewConstantNode(Running))
+// Build all children of a composite node
+func (b *Builder) MakeChildren(root ProjectNode) ([]Node, error) {
+  var errs ErrorList
+  children := make([]Node, len(root.Children))
+  for idx, id := range root.Children {
+    if _, ok := b.Nodes[id]; !ok {
+      errs = append(errs, &NodeError{Id: root.Id, Name: root.Name, Ref: id, Err: ErrMissingNode})
+      continue
+    }
+    var err error
+    children[idx], err = b.MakeNode(id)
+    errs = errs.add(root, err)
   }
+  return children, errs.err()
 }
 
+// Build the child of a decorator node
+func (b *Builder) MakeChild(root ProjectNode) (Node, error) {
+  if _, ok := b.Nodes[root.Child]; !ok {
+    return nil, &NodeError{Id: root.Id, Name: root.Name, Ref: root.Child, Err: ErrMissingNode}
+  }
+  return b.MakeNode(root.Child)
+}
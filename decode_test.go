@@ -0,0 +1,421 @@
+package behaviortree
+
+import (
+  "testing"
+  "strings"
+  "errors"
+  "time"
+  "reflect"
+)
+
+const brokenProject = `{
+  "name": "broken",
+  "data": {"trees": [
+    {"title": "good", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "Sequence", "children": ["b", "c"]},
+      "b": {"id": "b", "name": "Succeeder"},
+      "c": {"id": "c", "name": "Inverter", "child": "b"}
+    }},
+    {"title": "bad", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "Priority", "children": ["b", "x", "c"]},
+      "b": {"id": "b", "name": "Succeder"},
+      "c": {"id": "c", "name": "Inverter"}
+    }}
+  ]}
+}`
+
+func TestMakeTreesErrors(t *testing.T) {
+  pr, err := ReadProject(strings.NewReader(brokenProject))
+  if err != nil {
+    t.Fatalf("Read failed: %s", err)
+  }
+  trees := make(map[string]Node)
+  err = MakeTrees(pr, trees)
+  var errs ErrorList
+  if !errors.As(err, &errs) {
+    t.Fatalf("Expected ErrorList, got %v", err)
+  }
+  if len(errs) != 3 {
+    t.Fatalf("Expected 3 errors, got %d:\n%s", len(errs), err)
+  }
+  expected := []struct {
+    id, ref string
+    err error
+  }{
+    {"b", "", ErrUnknownNode},
+    {"a", "x", ErrMissingNode},
+    {"c", "", ErrMissingNode},
+  }
+  for idx, exp := range expected {
+    if errs[idx].Tree != "bad" || errs[idx].Id != exp.id || errs[idx].Ref != exp.ref || !errors.Is(errs[idx], exp.err) {
+      t.Errorf("Unexpected error at index %d: %s", idx, errs[idx])
+    }
+  }
+  if _, ok := trees["bad"]; ok {
+    t.Errorf("Broken tree was built")
+  }
+  expectSequence(t, trees["good"], []Status{Failure})
+}
+
+func TestProperties(t *testing.T) {
+  n := ProjectNode{Id: "p", Name: "Custom", Properties: map[string]interface{}{
+    "count": 3.0,
+    "half": 1.5,
+    "ms": 250.0,
+    "wait": "2s",
+    "flag": "true",
+    "mode": "fast",
+    "until": "RUNNING",
+  }}
+  if i, err := n.Int("count", 0); i != 3 || err != nil {
+    t.Errorf("Int: %d %v", i, err)
+  }
+  if _, err := n.Int("half", 0); !errors.Is(err, ErrPropertyValue) {
+    t.Errorf("Int accepted a fraction: %v", err)
+  }
+  if i, err := n.Int("missing", 7); i != 7 || err != nil {
+    t.Errorf("Int default: %d %v", i, err)
+  }
+  if d, err := n.Duration("ms", 0); d != 250*time.Millisecond || err != nil {
+    t.Errorf("Duration: %s %v", d, err)
+  }
+  if d, err := n.Duration("wait", 0); d != 2*time.Second || err != nil {
+    t.Errorf("Duration string: %s %v", d, err)
+  }
+  if b, err := n.Bool("flag", false); !b || err != nil {
+    t.Errorf("Bool: %t %v", b, err)
+  }
+  if _, err := n.Enum("mode", "slow", "slow", "normal"); !errors.Is(err, ErrPropertyValue) {
+    t.Errorf("Enum accepted %v", err)
+  }
+  if s, err := n.Status("until", Success); s != Running || err != nil {
+    t.Errorf("Status: %s %v", s, err)
+  }
+  if _, err := n.String("count", ""); !errors.Is(err, ErrPropertyType) {
+    t.Errorf("String accepted a number: %v", err)
+  }
+}
+
+func TestDecodeProperties(t *testing.T) {
+  n := ProjectNode{Id: "p", Name: "Custom", Properties: map[string]interface{}{
+    "count": 3.0,
+    "ms": 250.0,
+    "mode": "fast",
+    "until": "Success",
+  }}
+  props := struct {
+    Count int `b3:"count"`
+    Wait time.Duration `b3:"ms"`
+    Mode string `b3:"mode,enum=fast|slow"`
+    Until Status `b3:"until"`
+    Other float64 `b3:"other"`
+  }{Other: 0.5}
+  if err := n.Decode(&props); err != nil {
+    t.Fatalf("Decode failed: %s", err)
+  }
+  if props.Count != 3 || props.Wait != 250*time.Millisecond || props.Mode != "fast" || props.Until != Success || props.Other != 0.5 {
+    t.Errorf("Unexpected result: %+v", props)
+  }
+
+  bad := struct {
+    Mode int `b3:"mode"`
+    Name string `b3:"name,required"`
+  }{}
+  var errs ErrorList
+  if err := n.Decode(&bad); !errors.As(err, &errs) || len(errs) != 2 {
+    t.Fatalf("Expected 2 errors, got %v", err)
+  }
+  if !errors.Is(errs[0], ErrPropertyType) || !errors.Is(errs[1], ErrMissingProperty) || errs[1].Id != "p" {
+    t.Errorf("Unexpected errors: %s", errs)
+  }
+}
+
+func TestRegistry(t *testing.T) {
+  nodes := map[string]ProjectNode{
+    "a": {Id: "a", Name: "Sequence", Children: []string{"b"}},
+    "b": {Id: "b", Name: "Custom"},
+  }
+  reg := DefaultRegistry()
+  reg.Register("Custom", func(b *Builder, root ProjectNode) (Node, error) {
+    return NewConstantNode(Running), nil
+  })
+  clone := reg.Clone()
+  clone.Register("Custom", func(b *Builder, root ProjectNode) (Node, error) {
+    return NewConstantNode(Success), nil
+  })
+
+  n, err := reg.MakeNode("a", nodes)
+  if err != nil {
+    t.Fatalf("MakeNode failed: %s", err)
+  }
+  expectSequence(t, n, []Status{Running})
+  n, err = clone.MakeNode("a", nodes)
+  if err != nil {
+    t.Fatalf("MakeNode failed: %s", err)
+  }
+  expectSequence(t, n, []Status{Success})
+  if _, err := MakeNode("a", nodes); !errors.Is(err, ErrUnknownNode) {
+    t.Errorf("Custom node leaked into the global registry: %v", err)
+  }
+  if _, ok := DefaultRegistry().Lookup("Custom"); ok {
+    t.Errorf("Custom node leaked into the default registry")
+  }
+
+  clone.Register("Custom", func(b *Builder, root ProjectNode) (Node, error) {
+    return nil, nil
+  })
+  n, err = clone.MakeNode("a", nodes)
+  var nerr *NodeError
+  if n != nil || !errors.As(err, &nerr) || nerr.Id != "b" || !errors.Is(err, ErrNilNode) {
+    t.Errorf("Nil node not reported: %v %v", n, err)
+  }
+}
+
+func TestDecodeDecorators(t *testing.T) {
+  for _, c := range []struct {
+    nodes map[string]ProjectNode
+    check func(n Node) bool
+  }{
+    {map[string]ProjectNode{
+      "a": {Id: "a", Name: "Retry", Child: "b", Properties: map[string]interface{}{"maxAttempts": 3.0, "backoff": 100.0, "jitter": 0.1}},
+      "b": {Id: "b", Name: "Failer"},
+    }, func(n Node) bool {
+      r := n.(*RetryNode)
+      return r.MaxAttempts == 3 && r.Backoff == 100*time.Millisecond && r.Multiplier == 2 && r.Jitter == 0.1
+    }},
+    {map[string]ProjectNode{
+      "a": {Id: "a", Name: "Cooldown", Child: "b", Properties: map[string]interface{}{"cooldown": 500.0, "status": "Success"}},
+      "b": {Id: "b", Name: "Failer"},
+    }, func(n Node) bool {
+      c := n.(*CooldownNode)
+      return c.Cooldown == 500*time.Millisecond && c.Cooling == Success
+    }},
+    {map[string]ProjectNode{
+      "a": {Id: "a", Name: "RateLimit", Child: "b", Properties: map[string]interface{}{"limit": 3.0, "window": "1m"}},
+      "b": {Id: "b", Name: "Succeeder"},
+    }, func(n Node) bool {
+      r := n.(*RateLimitNode)
+      return r.Limit == 3 && r.Window == time.Minute && r.Limited == Failure
+    }},
+    {map[string]ProjectNode{
+      "a": {Id: "a", Name: "MaxTime", Child: "b", Properties: map[string]interface{}{"maxTime": 250.0}},
+      "b": {Id: "b", Name: "MaxTicks", Child: "c", Properties: map[string]interface{}{"maxTicks": 4.0}},
+      "c": {Id: "c", Name: "Runner"},
+    }, func(n Node) bool {
+      m := n.(*MaxTimeNode)
+      return m.MaxTime == 250*time.Millisecond && m.Child.(*MaxTicksNode).MaxTicks == 4
+    }},
+  } {
+    root, err := MakeNode("a", c.nodes)
+    if err != nil {
+      t.Fatalf("MakeNode %s failed: %s", c.nodes["a"].Name, err)
+    }
+    if !c.check(root) {
+      t.Errorf("Unexpected %s %+v", c.nodes["a"].Name, root)
+    }
+  }
+}
+
+const subtreeProject = `{
+  "name": "subtrees",
+  "data": {"trees": [
+    {"id": "t-main", "title": "main", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "Sequence", "children": ["b", "c"]},
+      "b": {"id": "b", "name": "t-leaf"},
+      "c": {"id": "c", "name": "leaf"}
+    }},
+    {"id": "t-leaf", "title": "leaf", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "Inverter", "child": "b"},
+      "b": {"id": "b", "name": "Failer"}
+    }},
+    {"id": "t-loop", "title": "loop", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "Priority", "children": ["b"]},
+      "b": {"id": "b", "name": "t-loop2"}
+    }},
+    {"id": "t-loop2", "title": "loop2", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "loop"}
+    }}
+  ]}
+}`
+
+func TestSubtrees(t *testing.T) {
+  pr, err := ReadProject(strings.NewReader(subtreeProject))
+  if err != nil {
+    t.Fatalf("Read failed: %s", err)
+  }
+  trees := make(map[string]Node)
+  err = MakeTrees(pr, trees)
+  if !errors.Is(err, ErrSubtreeCycle) {
+    t.Fatalf("Expected a cycle error, got %v", err)
+  }
+  if len(err.(ErrorList)) != 1 {
+    t.Errorf("Expected the cycle to be listed once, got %s", err)
+  }
+  if _, ok := trees["loop"]; ok {
+    t.Errorf("Recursive tree was built")
+  }
+  if _, ok := trees["loop2"]; ok {
+    t.Errorf("Recursive tree was built")
+  }
+  main := trees["main"].(*SequentialNode)
+  expectSequence(t, main, []Status{Success})
+  if main.Children[0] == main.Children[1] || main.Children[0] == trees["leaf"] {
+    t.Errorf("Subtrees are shared")
+  }
+
+  b := &Builder{Registry: DefaultRegistry(), Project: pr, SharedSubtrees: true}
+  shared := make(map[string]Node)
+  b.MakeTrees(shared)
+  main = shared["main"].(*SequentialNode)
+  if main.Children[0] != main.Children[1] || main.Children[0] != shared["leaf"] {
+    t.Errorf("Subtrees are not shared")
+  }
+
+  pr, err = ReadProject(strings.NewReader(`{"data": {"trees": [
+    {"id": "t-a", "title": "A", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "Sequence", "children": ["b", "c"]},
+      "b": {"id": "b", "name": "B"},
+      "c": {"id": "c", "name": "B"}
+    }},
+    {"id": "t-b", "title": "B", "root": "a", "nodes": {
+      "a": {"id": "a", "name": "Nope"}
+    }}
+  ]}}`))
+  if err != nil {
+    t.Fatalf("Read failed: %s", err)
+  }
+  trees = make(map[string]Node)
+  err = MakeTrees(pr, trees)
+  if errs, ok := err.(ErrorList); !ok || len(errs) != 1 || errs[0].Tree != "B" || !errors.Is(err, ErrUnknownNode) {
+    t.Errorf("Expected the broken subtree to be listed once, got %s", err)
+  }
+  if len(trees) != 0 {
+    t.Errorf("Broken trees were built: %v", trees)
+  }
+}
+
+const treeExport = `{
+  "version": "0.3.0",
+  "scope": "tree",
+  "id": "t1",
+  "title": "guard",
+  "description": "patrols",
+  "root": "a",
+  "properties": {"owner": "ai"},
+  "nodes": {
+    "a": {"id": "a", "name": "Sequence", "title": "Sequence", "description": "", "properties": {}, "display": {"x": 0, "y": 0}, "children": ["b", "c"]},
+    "b": {"id": "b", "name": "SeeEnemy", "title": "See", "description": "", "properties": {"range": "far"}, "display": {"x": 100, "y": -50}},
+    "c": {"id": "c", "name": "Attack", "title": "Attack", "description": "", "properties": {"power": 3, "color": "red"}, "display": {"x": 100, "y": 50}, "child": "b"}
+  },
+  "display": {"camera_x": 10, "camera_y": 20, "camera_z": 1, "x": 0, "y": 0},
+  "custom_nodes": [
+    {"version": "0.3.0", "scope": "node", "name": "SeeEnemy", "category": "condition", "title": "", "description": "", "properties": {"range": 5}},
+    {"version": "0.3.0", "scope": "node", "name": "Attack", "category": "action", "title": "", "description": "", "properties": {"power": 1}}
+  ]
+}`
+
+func TestProjectFormat(t *testing.T) {
+  pr, err := ReadProject(strings.NewReader(treeExport))
+  if err != nil {
+    t.Fatalf("Read failed: %s", err)
+  }
+  if pr.Format != TreeExport || len(pr.Data.Trees) != 1 {
+    t.Fatalf("Not read as a tree export: %+v", pr)
+  }
+  tree := pr.Data.Trees[0]
+  if tree.Id != "t1" || tree.Description != "patrols" || tree.Properties["owner"] != "ai" || tree.Display.CameraY != 20 {
+    t.Errorf("Tree fields missing: %+v", tree)
+  }
+  if tree.Nodes["b"].Display.Y != -50 {
+    t.Errorf("Node display missing: %+v", tree.Nodes["b"])
+  }
+
+  var buf strings.Builder
+  if err := WriteProject(&buf, pr); err != nil {
+    t.Fatalf("Write failed: %s", err)
+  }
+  again, err := ReadProject(strings.NewReader(buf.String()))
+  if err != nil {
+    t.Fatalf("Read failed: %s", err)
+  }
+  if !reflect.DeepEqual(pr, again) {
+    t.Errorf("Round trip lost data:\n%+v\n%+v", pr, again)
+  }
+
+  var errs ErrorList
+  if err := pr.Validate(); !errors.As(err, &errs) || len(errs) != 3 {
+    t.Fatalf("Expected 3 errors, got %v", err)
+  }
+  if !errors.Is(errs[0], ErrPropertyType) || errs[0].Id != "b" {
+    t.Errorf("Expected a type error, got %s", errs[0])
+  }
+  if !errors.Is(errs[1], ErrNodeCategory) || errs[1].Id != "c" {
+    t.Errorf("Expected a category error, got %s", errs[1])
+  }
+  if !errors.Is(errs[2], ErrUnknownProperty) || errs[2].Tree != "guard" {
+    t.Errorf("Expected an undeclared property error, got %s", errs[2])
+  }
+}
+
+func TestEncode(t *testing.T) {
+  n := NewSequentialNode([]Node{
+    NewParallelNodeBounded(1, 2, []Node{
+      NewRepeaterNode(3, NewConstantNode(Success)),
+      NewInverterNode(NewConstantNode(Failure)),
+    }),
+    NewTimeoutNode(5*time.Millisecond, Success, NewConstantNode(Running)),
+    NewTimeoutNode(time.Second, Failure, NewWrapConstantNode(Failure, NewConstantNode(Running))),
+  })
+  pr, err := EncodeTree("code", n)
+  if err != nil {
+    t.Fatalf("Encode failed: %s", err)
+  }
+  var buf strings.Builder
+  if err := WriteProject(&buf, pr); err != nil {
+    t.Fatalf("Write failed: %s", err)
+  }
+  t.Log(buf.String())
+  pr, err = ReadProject(strings.NewReader(buf.String()))
+  if err != nil {
+    t.Fatalf("Read failed: %s", err)
+  }
+  if err := pr.Validate(); err != nil {
+    t.Errorf("Validate failed: %s", err)
+  }
+  if c, ok := pr.CustomNode("ParallelSequence"); !ok || c.Category != "composite" {
+    t.Errorf("ParallelSequence not declared as composite: %+v", c)
+  }
+
+  trees := make(map[string]Node)
+  if err := MakeTrees(pr, trees); err != nil {
+    t.Fatalf("MakeTrees failed: %s", err)
+  }
+  seq := trees["code"].(*SequentialNode)
+  par := seq.Children[0].(*ParallelNode)
+  if par.MinimumSuccesses != 1 || par.MinimumFailures != 2 || par.Children[0].(*RepeaterNode).Limit != 3 {
+    t.Errorf("Properties not preserved: %+v", par)
+  }
+  sleep := seq.Children[1].(*TimeoutNode)
+  if sleep.Timeout != 5*time.Millisecond || sleep.Completion != Success {
+    t.Errorf("Sleep not preserved: %+v", sleep)
+  }
+  timeout := seq.Children[2].(*TimeoutNode)
+  if timeout.Timeout != time.Second || timeout.Completion != Failure {
+    t.Errorf("Timeout not preserved: %+v", timeout)
+  }
+
+  pred := NewPredicateLeafNode(func(state interface{}) bool { return true })
+  if _, err := EncodeTree("pred", NewInverterNode(pred)); !errors.Is(err, ErrNotEncodable) {
+    t.Errorf("Expected ErrNotEncodable, got %v", err)
+  }
+  e := &Encoder{Fallback: func(e *Encoder, node Node) (ProjectNode, error) {
+    return ProjectNode{Name: "AlwaysTrue"}, nil
+  }}
+  pr, err = e.EncodeTree("pred", NewInverterNode(pred))
+  if err != nil {
+    t.Fatalf("Encode failed: %s", err)
+  }
+  if c, ok := pr.CustomNode("AlwaysTrue"); !ok || c.Category != "action" {
+    t.Errorf("Fallback node not declared: %+v", pr.Data.Trees[0].CustomNodes)
+  }
+}
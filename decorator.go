@@ -1,11 +1,20 @@
 package behaviortree
 
-import "time"
+import (
+  "fmt"
+  "math"
+  "time"
+)
 
 type Decorator struct {
   Child Node
 }
 
+// Halt the child if it is still running
+func (n *Decorator) TerminateAgent(a *Agent) {
+  a.Halt(n.Child)
+}
+
 // A node that turns failure into success
 // Do you want one for your life?
 // Be carefull what you ask for,
@@ -16,16 +25,35 @@ type InverterNode struct {
 }
 
 func (n *InverterNode) Update(state interface{}, messages []interface{}) []interface{} {
-  status, messages := Tick(n.Child, state, messages)
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *InverterNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  status, messages := a.Tick(n.Child, state, messages)
   switch status {
   case Success:
-    n.Status = Failure
+    return Failure, messages
   case Failure:
-    n.Status = Success
+    return Success, messages
   default:
-    n.Status = status
+    return status, messages
   }
-  return messages
+}
+
+func (n *InverterNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *InverterNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Decorator("Inverter", nil, n.Child)
+}
+
+func (n *InverterNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.cloneChildren()
+  return &c
 }
 
 func NewInverterNode(child Node) *InverterNode {
@@ -34,17 +62,50 @@ func NewInverterNode(child Node) *InverterNode {
   return n
 }
 
-// Runs the child and always returns the same status
+// Runs the child and always returns the same status,
+// unless the child returns Error
 type WrapConstantNode struct {
   BasicNode
   Decorator
 }
 
 func (n *WrapConstantNode) Update(state interface{}, messages []interface{}) []interface{} {
-  _, messages = Tick(n.Child, state, messages)
+  _, messages = n.UpdateAgent(new(Agent), state, messages)
   return messages
 }
 
+func (n *WrapConstantNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  status, messages := a.Tick(n.Child, state, messages)
+  if status == Error {
+    return Error, messages
+  }
+  return n.Status, messages
+}
+
+// The status is the constant, ticking does not change it
+func (n *WrapConstantNode) SetStatus(status Status) {}
+
+func (n *WrapConstantNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *WrapConstantNode) Encode(e *Encoder) (ProjectNode, error) {
+  switch n.Status {
+  case Success:
+    return e.Decorator("SucceederDec", nil, n.Child)
+  case Failure:
+    return e.Decorator("FailerDec", nil, n.Child)
+  default:
+    return ProjectNode{}, fmt.Errorf("%w: wrap constant %s", ErrNotEncodable, n.Status)
+  }
+}
+
+func (n *WrapConstantNode) Clone() Node {
+  c := *n
+  c.cloneChildren()
+  return &c
+}
+
 func NewWrapConstantNode(status Status, child Node) *WrapConstantNode {
   n := new(WrapConstantNode)
   n.Child = child
@@ -53,6 +114,7 @@ func NewWrapConstantNode(status Status, child Node) *WrapConstantNode {
 }
 
 // Runs the child until limit is reached
+// or the child returns Error
 type RepeaterNode struct {
   BasicNode
   Decorator
@@ -61,20 +123,48 @@ type RepeaterNode struct {
 }
 
 func (n *RepeaterNode) Initiate() {
-  n.Counter = 0
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *RepeaterNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.Counter) = 0
 }
 
 func (n *RepeaterNode) Update(state interface{}, messages []interface{}) []interface{} {
-  status, messages := Tick(n.Child, state, messages)
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *RepeaterNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  counter := agentMemory(a, n, &n.Counter)
+  status, messages := a.Tick(n.Child, state, messages)
+  if status == Error {
+    return Error, messages
+  }
   if status != Running {
-    n.Counter++
+    *counter++
   }
-  if n.Limit < 1 || n.Counter < n.Limit {
-    n.Status = Running
+  if n.Limit < 1 || *counter < n.Limit {
+    return Running, messages
   } else {
-    n.Status = status
+    return status, messages
   }
-  return messages
+}
+
+func (n *RepeaterNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *RepeaterNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Decorator("Repeat", map[string]interface{}{"limit": n.Limit}, n.Child)
+}
+
+func (n *RepeaterNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.Counter = 0
+  c.cloneChildren()
+  return &c
 }
 
 func NewRepeaterNode(limit int, child Node) *RepeaterNode {
@@ -85,6 +175,7 @@ func NewRepeaterNode(limit int, child Node) *RepeaterNode {
 }
 
 // Repeat Until the given status
+// or the child returns Error
 type RepeatUntilNode struct {
   BasicNode
   Decorator
@@ -92,13 +183,41 @@ type RepeatUntilNode struct {
 }
 
 func (n *RepeatUntilNode) Update(state interface{}, messages []interface{}) []interface{} {
-  status, messages := Tick(n.Child, state, messages)
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *RepeatUntilNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  status, messages := a.Tick(n.Child, state, messages)
   if status == n.Until {
-    n.Status = Success
+    return Success, messages
+  } else if status == Error {
+    return Error, messages
   } else {
-    n.Status = Running
+    return Running, messages
   }
-  return messages
+}
+
+func (n *RepeatUntilNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *RepeatUntilNode) Encode(e *Encoder) (ProjectNode, error) {
+  switch n.Until {
+  case Success:
+    return e.Decorator("RepeatUntilSuccess", nil, n.Child)
+  case Failure:
+    return e.Decorator("RepeatUntilFailure", nil, n.Child)
+  default:
+    return ProjectNode{}, fmt.Errorf("%w: repeat until %s", ErrNotEncodable, n.Until)
+  }
+}
+
+func (n *RepeatUntilNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.cloneChildren()
+  return &c
 }
 
 func NewRepeatUntilNode(until Status, child Node) *RepeatUntilNode {
@@ -108,28 +227,59 @@ func NewRepeatUntilNode(until Status, child Node) *RepeatUntilNode {
   return n
 }
 
+// Runs the child until it is done or the timeout passed,
+// then returns Completion
+// Time is measured by the clock of the agent
 type TimeoutNode struct {
   BasicNode
   Decorator
   Timeout time.Duration
-  tchan <-chan time.Time
+  deadline time.Time
   Completion Status
 }
 
 func (n *TimeoutNode) Initiate() {
-  n.tchan = time.After(n.Timeout)
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *TimeoutNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.deadline) = a.Now().Add(n.Timeout)
 }
 
 func (n *TimeoutNode) Update(state interface{}, messages []interface{}) []interface{} {
-  select {
-  case <-n.tchan:
-    n.Status = n.Completion
-  default:
-    n.Status, messages = Tick(n.Child, state, messages)
-  }
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
   return messages
 }
 
+func (n *TimeoutNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  if !a.Now().Before(*agentMemory(a, n, &n.deadline)) {
+    return n.Completion, messages
+  }
+  return a.Tick(n.Child, state, messages)
+}
+
+func (n *TimeoutNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+// A timeout around a running constant is a Sleep node
+func (n *TimeoutNode) Encode(e *Encoder) (ProjectNode, error) {
+  ms := float64(n.Timeout)/float64(time.Millisecond)
+  if c, ok := n.Child.(*BasicNode); ok && c.Status == Running && n.Completion == Success {
+    return ProjectNode{Name: "Sleep", Properties: map[string]interface{}{"ms": ms}}, nil
+  }
+  props := map[string]interface{}{"ms": ms, "completion": n.Completion.String()}
+  return e.Decorator("Timeout", props, n.Child)
+}
+
+func (n *TimeoutNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.deadline = time.Time{}
+  c.cloneChildren()
+  return &c
+}
+
 func NewTimeoutNode(timeout time.Duration, completion Status, child Node) *TimeoutNode {
   n := new(TimeoutNode)
   n.Child = child
@@ -137,3 +287,343 @@ func NewTimeoutNode(timeout time.Duration, completion Status, child Node) *Timeo
   n.Completion = completion
   return n
 }
+
+// Runs the child again when it fails,
+// up to MaxAttempts times when that is positive
+// Between attempts it waits, starting with Backoff
+// and multiplying the wait by Multiplier after every failure,
+// up to MaxBackoff when that is positive
+// Jitter varies each wait randomly by up to that fraction
+// Waiting is Running, time is measured by the clock of the agent
+type RetryNode struct {
+  BasicNode
+  Decorator
+  RetryMemory
+  MaxAttempts int
+  Backoff time.Duration
+  MaxBackoff time.Duration
+  Multiplier float64
+  Jitter float64
+}
+
+// What a RetryNode remembers between ticks
+type RetryMemory struct {
+  // The failed attempts so far
+  Attempts int
+  // When the next attempt may start
+  Next time.Time
+}
+
+func (n *RetryNode) Initiate() {
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *RetryNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.RetryMemory) = RetryMemory{}
+}
+
+func (n *RetryNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+// Each failed attempt is traced as TraceRetry
+func (n *RetryNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  m := agentMemory(a, n, &n.RetryMemory)
+  if a.Now().Before(m.Next) {
+    return Running, messages
+  }
+  status, messages := a.Tick(n.Child, state, messages)
+  if status != Failure {
+    return status, messages
+  }
+  m.Attempts++
+  a.traceRetry(n, m.Attempts, messages)
+  if n.MaxAttempts > 0 && m.Attempts >= n.MaxAttempts {
+    return Failure, messages
+  }
+  m.Next = a.Now().Add(n.delay(a, m.Attempts))
+  return Running, messages
+}
+
+// The wait after the given number of failed attempts
+// Jitter uses the random source of the agent
+func (n *RetryNode) delay(a *Agent, attempts int) time.Duration {
+  d := float64(n.Backoff) * math.Pow(n.Multiplier, float64(attempts-1))
+  if n.MaxBackoff > 0 && d > float64(n.MaxBackoff) {
+    d = float64(n.MaxBackoff)
+  }
+  if n.Jitter > 0 {
+    d *= 1 + n.Jitter*(2*a.float64()-1)
+  }
+  return time.Duration(d)
+}
+
+func (n *RetryNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *RetryNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Decorator("Retry", map[string]interface{}{
+    "maxAttempts": n.MaxAttempts,
+    "backoff": float64(n.Backoff)/float64(time.Millisecond),
+    "maxBackoff": float64(n.MaxBackoff)/float64(time.Millisecond),
+    "multiplier": n.Multiplier,
+    "jitter": n.Jitter,
+  }, n.Child)
+}
+
+func (n *RetryNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.RetryMemory = RetryMemory{}
+  c.cloneChildren()
+  return &c
+}
+
+// Create a retry node that doubles the wait after every failure
+func NewRetryNode(maxAttempts int, backoff time.Duration, child Node) *RetryNode {
+  n := new(RetryNode)
+  n.Child = child
+  n.MaxAttempts = maxAttempts
+  n.Backoff = backoff
+  n.Multiplier = 2
+  return n
+}
+
+// Keeps the child from running again until Cooldown passed
+// since it last completed, returning Cooling instead
+// Time is measured by the clock of the agent,
+// the cooldown lasts across activations of the node
+type CooldownNode struct {
+  BasicNode
+  Decorator
+  Cooldown time.Duration
+  Cooling Status
+  until time.Time
+}
+
+func (n *CooldownNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *CooldownNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  until := agentMemory(a, n, &n.until)
+  if a.Status(n.Child) != Running && a.Now().Before(*until) {
+    return n.Cooling, messages
+  }
+  status, messages := a.Tick(n.Child, state, messages)
+  if status != Running && !a.cancelled() {
+    *until = a.Now().Add(n.Cooldown)
+  }
+  return status, messages
+}
+
+func (n *CooldownNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *CooldownNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Decorator("Cooldown", map[string]interface{}{
+    "cooldown": float64(n.Cooldown)/float64(time.Millisecond),
+    "status": n.Cooling.String(),
+  }, n.Child)
+}
+
+func (n *CooldownNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.until = time.Time{}
+  c.cloneChildren()
+  return &c
+}
+
+// Create a cooldown node that fails while cooling down
+func NewCooldownNode(cooldown time.Duration, child Node) *CooldownNode {
+  n := new(CooldownNode)
+  n.Child = child
+  n.Cooldown = cooldown
+  n.Cooling = Failure
+  return n
+}
+
+// Starts the child at most Limit times per Window,
+// returning Limited instead
+// Ticks of a running child do not count
+// Time is measured by the clock of the agent
+type RateLimitNode struct {
+  BasicNode
+  Decorator
+  Limit int
+  Window time.Duration
+  Limited Status
+  starts []time.Time
+}
+
+func (n *RateLimitNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *RateLimitNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  if a.Status(n.Child) != Running {
+    starts := agentMemory(a, n, &n.starts)
+    now := a.Now()
+    recent := (*starts)[:0]
+    for _, start := range *starts {
+      if now.Sub(start) < n.Window {
+        recent = append(recent, start)
+      }
+    }
+    *starts = recent
+    if len(recent) >= n.Limit {
+      return n.Limited, messages
+    }
+    *starts = append(recent, now)
+  }
+  return a.Tick(n.Child, state, messages)
+}
+
+func (n *RateLimitNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *RateLimitNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Decorator("RateLimit", map[string]interface{}{
+    "limit": n.Limit,
+    "window": float64(n.Window)/float64(time.Millisecond),
+    "status": n.Limited.String(),
+  }, n.Child)
+}
+
+func (n *RateLimitNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.starts = nil
+  c.cloneChildren()
+  return &c
+}
+
+// Create a rate limit node that fails when the limit is reached
+func NewRateLimitNode(limit int, window time.Duration, child Node) *RateLimitNode {
+  n := new(RateLimitNode)
+  n.Child = child
+  n.Limit = limit
+  n.Window = window
+  n.Limited = Failure
+  return n
+}
+
+// Ticks the child, but when it is still running
+// after MaxTime, halts it and fails
+// Like the MaxTime node of behavior3
+// Time is measured by the clock of the agent
+type MaxTimeNode struct {
+  BasicNode
+  Decorator
+  MaxTime time.Duration
+  deadline time.Time
+}
+
+func (n *MaxTimeNode) Initiate() {
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *MaxTimeNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.deadline) = a.Now().Add(n.MaxTime)
+}
+
+func (n *MaxTimeNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *MaxTimeNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  status, messages := a.Tick(n.Child, state, messages)
+  if status == Running && !a.Now().Before(*agentMemory(a, n, &n.deadline)) {
+    a.Halt(n.Child)
+    return Failure, messages
+  }
+  return status, messages
+}
+
+func (n *MaxTimeNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *MaxTimeNode) Encode(e *Encoder) (ProjectNode, error) {
+  ms := float64(n.MaxTime)/float64(time.Millisecond)
+  return e.Decorator("MaxTime", map[string]interface{}{"maxTime": ms}, n.Child)
+}
+
+func (n *MaxTimeNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.deadline = time.Time{}
+  c.cloneChildren()
+  return &c
+}
+
+func NewMaxTimeNode(maxTime time.Duration, child Node) *MaxTimeNode {
+  n := new(MaxTimeNode)
+  n.Child = child
+  n.MaxTime = maxTime
+  return n
+}
+
+// Like MaxTimeNode, but fails the child
+// when it is still running after MaxTicks ticks
+type MaxTicksNode struct {
+  BasicNode
+  Decorator
+  MaxTicks int
+  Ticks int
+}
+
+func (n *MaxTicksNode) Initiate() {
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *MaxTicksNode) InitiateAgent(a *Agent) {
+  *agentMemory(a, n, &n.Ticks) = 0
+}
+
+func (n *MaxTicksNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *MaxTicksNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  ticks := agentMemory(a, n, &n.Ticks)
+  *ticks++
+  status, messages := a.Tick(n.Child, state, messages)
+  if status == Running && *ticks >= n.MaxTicks {
+    a.Halt(n.Child)
+    return Failure, messages
+  }
+  return status, messages
+}
+
+func (n *MaxTicksNode) Terminate() {
+  n.TerminateAgent(new(Agent))
+}
+
+func (n *MaxTicksNode) Encode(e *Encoder) (ProjectNode, error) {
+  return e.Decorator("MaxTicks", map[string]interface{}{"maxTicks": n.MaxTicks}, n.Child)
+}
+
+func (n *MaxTicksNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.Ticks = 0
+  c.cloneChildren()
+  return &c
+}
+
+func NewMaxTicksNode(maxTicks int, child Node) *MaxTicksNode {
+  n := new(MaxTicksNode)
+  n.Child = child
+  n.MaxTicks = maxTicks
+  return n
+}
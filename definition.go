@@ -0,0 +1,85 @@
+package behaviortree
+
+import "sync"
+
+// A tree that is shared by many agents
+// Ticking it through an Instance leaves nodes that implement AgentNode,
+// like all built-in nodes, untouched, so the nodes are built only once.
+// Other nodes keep their memory in themselves,
+// and are shared by all instances
+// The nodes are indexed on the first Instantiate,
+// nodes added to the tree later are kept in the blackboard
+type Definition struct {
+  Root Node
+  // The clock given to new instances, may be nil
+  Clock Clock
+
+  once sync.Once
+  // Dense index of every AgentNode below Root
+  index map[Node]int
+}
+
+// Create a new definition of the tree below root
+func NewDefinition(root Node) *Definition {
+  d := new(Definition)
+  d.Root = root
+  return d
+}
+
+// Create a new instance of the tree with a blackboard of its own
+// The status and runtime memory of the nodes are kept in a slice,
+// not in the blackboard
+func (d *Definition) Instantiate() *Instance {
+  d.once.Do(d.indexNodes)
+  i := new(Instance)
+  i.Definition = d
+  i.Agent.Blackboard = NewBlackboard()
+  i.Agent.Tree = d
+  i.Agent.Clock = d.Clock
+  i.Agent.index = d.index
+  i.Agent.nodes = make([]nodeState, len(d.index))
+  return i
+}
+
+// Give every AgentNode below the root an index,
+// a node below several parents gets only one
+func (d *Definition) indexNodes() {
+  d.index = make(map[Node]int)
+  Walk(d.Root, func(path []Node, node Node) error {
+    if _, ok := node.(AgentNode); !ok {
+      return nil
+    }
+    if _, ok := d.index[node]; ok {
+      return SkipChildren
+    }
+    d.index[node] = len(d.index)
+    return nil
+  })
+}
+
+// One agent running a Definition
+// It holds only the status and memory of the nodes
+type Instance struct {
+  Definition *Definition
+  Agent Agent
+}
+
+// Tick the root of the tree for this instance
+func (i *Instance) Tick(state interface{}, messages []interface{}) (Status, []interface{}) {
+  return i.Agent.Tick(i.Definition.Root, state, messages)
+}
+
+// The status of a node of the tree for this instance
+func (i *Instance) Status(node Node) Status {
+  return i.Agent.Status(node)
+}
+
+// The memory of a node of the tree for this instance
+func (i *Instance) Memory(node Node) Memory {
+  return i.Agent.Memory(node)
+}
+
+// Why the last tick of this instance ended in Error, if it did
+func (i *Instance) Err() error {
+  return i.Agent.Err()
+}
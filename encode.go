@@ -0,0 +1,279 @@
+package behaviortree
+
+import (
+  "fmt"
+  "sort"
+  "errors"
+  "crypto/rand"
+)
+
+// A node cannot be described as a behavior3 node
+var ErrNotEncodable = errors.New("node cannot be encoded")
+
+// Implemented by nodes that can be written to a behavior3 project
+// Return the node with its Name and Properties filled in,
+// using the Composite and Decorator helpers for children
+type Encodable interface {
+  Encode(e *Encoder) (ProjectNode, error)
+}
+
+// Names the behavior3 editor knows without a custom node definition
+var editorNodes = map[string]bool{
+  "Sequence": true, "Priority": true, "MemSequence": true, "MemPriority": true,
+  "Repeater": true, "RepeaterUntilFailure": true, "RepeaterUntilSuccess": true,
+  "MaxTime": true, "Inverter": true, "Limiter": true,
+  "Failer": true, "Succeeder": true, "Runner": true, "Error": true, "Wait": true,
+}
+
+// Turns node trees into behavior3 project trees
+// Names the editor does not know are declared as custom nodes,
+// with the category they were encoded as
+type Encoder struct {
+  // Called for nodes that do not implement Encodable
+  Fallback func(e *Encoder, node Node) (ProjectNode, error)
+
+  tree string
+  nodes map[string]ProjectNode
+  custom map[string]*CustomNode
+  depth int
+  row int
+}
+
+// Describe a single tree built in Go
+// The result is written as a tree export by WriteProject
+func EncodeTree(title string, root Node) (*Project, error) {
+  return new(Encoder).EncodeTree(title, root)
+}
+
+// Describe several trees built in Go as one project
+func EncodeProject(name string, trees map[string]Node) (*Project, error) {
+  return new(Encoder).EncodeProject(name, trees)
+}
+
+// Like EncodeTree, using this encoder
+func (e *Encoder) EncodeTree(title string, root Node) (*Project, error) {
+  e.custom = nil
+  tree, err := e.encodeTree(title, root)
+  if err != nil {
+    return nil, err
+  }
+  tree.Scope = "tree"
+  tree.CustomNodes = e.customNodes()
+  pr := &Project{Name: title, Format: TreeExport}
+  pr.Data.Version = tree.Version
+  pr.Data.Trees = []ProjectTree{tree}
+  return pr, nil
+}
+
+// Like EncodeProject, using this encoder
+// Trees are ordered by title
+func (e *Encoder) EncodeProject(name string, trees map[string]Node) (*Project, error) {
+  e.custom = nil
+  titles := make([]string, 0, len(trees))
+  for title := range trees {
+    titles = append(titles, title)
+  }
+  sort.Strings(titles)
+
+  pr := &Project{Name: name, Format: ProjectFile}
+  pr.Data.Version = "0.3.0"
+  pr.Data.Scope = "project"
+  var errs ErrorList
+  for _, title := range titles {
+    tree, err := e.encodeTree(title, trees[title])
+    if err != nil {
+      errs = errs.add(ProjectNode{}, err)
+      continue
+    }
+    pr.Data.Trees = append(pr.Data.Trees, tree)
+  }
+  if len(errs) > 0 {
+    return nil, errs
+  }
+  if len(pr.Data.Trees) > 0 {
+    pr.Data.SelectedTree = pr.Data.Trees[0].Id
+  }
+  pr.Data.CustomNodes = e.customNodes()
+  return pr, nil
+}
+
+// Encode one tree
+func (e *Encoder) encodeTree(title string, root Node) (ProjectTree, error) {
+  e.tree = title
+  e.nodes = make(map[string]ProjectNode)
+  e.depth = 0
+  e.row = 0
+  id, err := e.Add(root)
+  if err != nil {
+    return ProjectTree{}, err
+  }
+  return ProjectTree{
+    Version: "0.3.0",
+    Id: newId(),
+    Title: title,
+    Root: id,
+    Properties: map[string]interface{}{},
+    Nodes: e.nodes,
+    Display: &TreeDisplay{CameraZ: 1},
+  }, nil
+}
+
+// Encode node and its descendants, returning the id of node
+func (e *Encoder) Add(node Node) (string, error) {
+  var pn ProjectNode
+  var err error
+  category := "action"
+  switch n := node.(type) {
+  case BasicNode:
+    pn, err = encodeConstant(n)
+  case *BasicNode:
+    pn, err = encodeConstant(*n)
+  case Encodable:
+    pn, err = n.Encode(e)
+  default:
+    if e.Fallback == nil {
+      err = fmt.Errorf("%w: %T", ErrNotEncodable, node)
+    } else {
+      pn, err = e.Fallback(e, node)
+    }
+  }
+  if err != nil {
+    errs := ErrorList(nil).add(ProjectNode{Name: fmt.Sprintf("%T", node)}, err)
+    for _, nerr := range errs {
+      if nerr.Tree == "" {
+        nerr.Tree = e.tree
+      }
+    }
+    return "", errs
+  }
+
+  pn.Id = newId()
+  if n, ok := node.(interface{ GetId() string }); ok && n.GetId() != "" {
+    if _, used := e.nodes[n.GetId()]; !used {
+      pn.Id = n.GetId()
+    }
+  }
+  if pn.Title == "" {
+    pn.Title = pn.Name
+  }
+  if pn.Properties == nil {
+    pn.Properties = map[string]interface{}{}
+  }
+  if pn.Display == nil {
+    pn.Display = e.layout(pn)
+  }
+  if pn.Child != "" {
+    category = "decorator"
+  } else if pn.Children != nil {
+    category = "composite"
+  }
+  e.declare(pn, category)
+  e.nodes[pn.Id] = pn
+  return pn.Id, nil
+}
+
+// Describe a composite node, adding its children
+func (e *Encoder) Composite(name string, properties map[string]interface{}, children []Node) (ProjectNode, error) {
+  pn := ProjectNode{Name: name, Properties: properties, Children: make([]string, 0, len(children))}
+  var errs ErrorList
+  e.depth++
+  for _, child := range children {
+    id, err := e.Add(child)
+    errs = errs.add(pn, err)
+    pn.Children = append(pn.Children, id)
+  }
+  e.depth--
+  return pn, errs.err()
+}
+
+// Describe a decorator node, adding its child
+func (e *Encoder) Decorator(name string, properties map[string]interface{}, child Node) (ProjectNode, error) {
+  pn := ProjectNode{Name: name, Properties: properties}
+  e.depth++
+  id, err := e.Add(child)
+  e.depth--
+  pn.Child = id
+  return pn, err
+}
+
+// Describe a node that always has the same status
+func encodeConstant(n BasicNode) (ProjectNode, error) {
+  switch n.Status {
+  case Success:
+    return ProjectNode{Name: "Succeeder"}, nil
+  case Failure:
+    return ProjectNode{Name: "Failer"}, nil
+  case Running:
+    return ProjectNode{Name: "Runner"}, nil
+  case Error:
+    return ProjectNode{Name: "Error"}, nil
+  default:
+    return ProjectNode{}, fmt.Errorf("%w: constant %s", ErrNotEncodable, n.Status)
+  }
+}
+
+// Place leaves below each other, and parents next to their children
+func (e *Encoder) layout(pn ProjectNode) *NodeDisplay {
+  d := &NodeDisplay{X: float64(e.depth*250)}
+  ids := pn.Children
+  if pn.Child != "" {
+    ids = []string{pn.Child}
+  }
+  if len(ids) == 0 {
+    d.Y = float64(e.row*100)
+    e.row++
+    return d
+  }
+  for _, id := range ids {
+    d.Y += e.nodes[id].Display.Y
+  }
+  d.Y /= float64(len(ids))
+  return d
+}
+
+// Remember names the editor needs a definition for
+func (e *Encoder) declare(pn ProjectNode, category string) {
+  if editorNodes[pn.Name] {
+    return
+  }
+  if e.custom == nil {
+    e.custom = make(map[string]*CustomNode)
+  }
+  c, ok := e.custom[pn.Name]
+  if !ok {
+    c = &CustomNode{
+      Version: "0.3.0",
+      Scope: "node",
+      Name: pn.Name,
+      Category: category,
+      Properties: map[string]interface{}{},
+    }
+    e.custom[pn.Name] = c
+  }
+  for key, value := range pn.Properties {
+    if _, ok := c.Properties[key]; !ok {
+      c.Properties[key] = value
+    }
+  }
+}
+
+// The custom node definitions, ordered by name
+func (e *Encoder) customNodes() []CustomNode {
+  nodes := make([]CustomNode, 0, len(e.custom))
+  for _, c := range e.custom {
+    nodes = append(nodes, *c)
+  }
+  sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+  return nodes
+}
+
+// Create a random UUID, like the editor uses for ids
+func newId() string {
+  var b [16]byte
+  if _, err := rand.Read(b[:]); err != nil {
+    panic(err)
+  }
+  b[6] = b[6]&0x0f | 0x40
+  b[8] = b[8]&0x3f | 0x80
+  return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,3 @@
+module github.com/pepijndevos/behavior3go
+
+go 1.20
@@ -0,0 +1,150 @@
+package behaviortree
+
+import (
+  "io"
+  "fmt"
+  "bufio"
+  "strings"
+)
+
+// Draws node trees as Graphviz DOT or Mermaid flowcharts
+// Nodes are labeled with their type and main properties
+type Graph struct {
+  // Color the nodes by their status
+  Status bool
+  // The status of a node, GetStatus when nil
+  // Use Instance.Status to draw the tree of one agent
+  StatusOf func(node Node) Status
+}
+
+// Fill colors for the statuses
+var statusColors = map[Status]string{
+  Failure: "#f4a6a6",
+  Success: "#a6e3a6",
+  Running: "#f9e08b",
+  Error: "#c9a6f4",
+}
+
+// Draw the tree below root as DOT
+func WriteDOT(w io.Writer, root Node) error {
+  return new(Graph).WriteDOT(w, root)
+}
+
+// Draw the tree below root as a Mermaid flowchart
+func WriteMermaid(w io.Writer, root Node) error {
+  return new(Graph).WriteMermaid(w, root)
+}
+
+// Like WriteDOT, with the options of this graph
+func (g *Graph) WriteDOT(w io.Writer, root Node) error {
+  b := bufio.NewWriter(w)
+  fmt.Fprintln(b, "digraph tree {")
+  fmt.Fprintln(b, "  node [shape=box];")
+  g.walk(root, func(id string, node Node) {
+    label := strings.Join(nodeLabel(node), "\n")
+    if color, ok := g.color(node); ok {
+      fmt.Fprintf(b, "  %s [label=%q, style=filled, fillcolor=%q];\n", id, label, color)
+    } else {
+      fmt.Fprintf(b, "  %s [label=%q];\n", id, label)
+    }
+  }, func(parent, child string) {
+    fmt.Fprintf(b, "  %s -> %s;\n", parent, child)
+  })
+  fmt.Fprintln(b, "}")
+  return b.Flush()
+}
+
+// Like WriteMermaid, with the options of this graph
+func (g *Graph) WriteMermaid(w io.Writer, root Node) error {
+  b := bufio.NewWriter(w)
+  fmt.Fprintln(b, "graph TD")
+  g.walk(root, func(id string, node Node) {
+    label := strings.ReplaceAll(strings.Join(nodeLabel(node), "<br/>"), `"`, "#quot;")
+    fmt.Fprintf(b, "  %s[\"%s\"]\n", id, label)
+    if color, ok := g.color(node); ok {
+      fmt.Fprintf(b, "  style %s fill:%s\n", id, color)
+    }
+  }, func(parent, child string) {
+    fmt.Fprintf(b, "  %s --> %s\n", parent, child)
+  })
+  return b.Flush()
+}
+
+// Visit every node once, depth first, and every edge
+// Nodes get ids in the order they are visited
+func (g *Graph) walk(root Node, node func(id string, n Node), edge func(parent, child string)) {
+  ids := make(map[Node]string)
+  var visit func(n Node) string
+  visit = func(n Node) string {
+    if id, ok := ids[n]; ok {
+      return id
+    }
+    id := fmt.Sprintf("n%d", len(ids))
+    ids[n] = id
+    node(id, n)
+    if p, ok := n.(Parent); ok {
+      for _, child := range p.ChildNodes() {
+        edge(id, visit(child))
+      }
+    }
+    return id
+  }
+  visit(root)
+}
+
+// The fill color of node, if coloring is enabled
+func (g *Graph) color(node Node) (string, bool) {
+  if !g.Status {
+    return "", false
+  }
+  status := node.GetStatus()
+  if g.StatusOf != nil {
+    status = g.StatusOf(node)
+  }
+  color, ok := statusColors[status]
+  return color, ok
+}
+
+// The type of node, followed by its main properties
+func nodeLabel(node Node) []string {
+  name := fmt.Sprintf("%T", node)
+  pkg := name
+  if idx := strings.Index(name, "["); idx >= 0 {
+    pkg = name[:idx]
+  }
+  name = name[strings.LastIndex(pkg, ".")+1:]
+  switch n := node.(type) {
+  case BasicNode:
+    return []string{name, "status=" + n.Status.String()}
+  case *BasicNode:
+    return []string{name, "status=" + n.Status.String()}
+  case *ParallelNode:
+    return []string{name, fmt.Sprintf("minSuccess=%d minFail=%d", n.MinimumSuccesses, n.MinimumFailures)}
+  case *ParallelMemoryNode:
+    return []string{name, fmt.Sprintf("minSuccess=%d minFail=%d", n.MinimumSuccesses, n.MinimumFailures)}
+  case *UtilitySelectorNode:
+    return []string{name, fmt.Sprintf("hysteresis=%g fallThrough=%t", n.Hysteresis, n.FallThrough)}
+  case *WeightedSelectorNode:
+    return []string{name, fmt.Sprintf("weights=%v", n.Weights)}
+  case *WrapConstantNode:
+    return []string{name, "status=" + n.Status.String()}
+  case *RepeaterNode:
+    return []string{name, fmt.Sprintf("limit=%d", n.Limit)}
+  case *RepeatUntilNode:
+    return []string{name, "until=" + n.Until.String()}
+  case *RetryNode:
+    return []string{name, fmt.Sprintf("maxAttempts=%d backoff=%s", n.MaxAttempts, n.Backoff)}
+  case *CooldownNode:
+    return []string{name, fmt.Sprintf("cooldown=%s", n.Cooldown)}
+  case *RateLimitNode:
+    return []string{name, fmt.Sprintf("limit=%d window=%s", n.Limit, n.Window)}
+  case *MaxTimeNode:
+    return []string{name, fmt.Sprintf("maxTime=%s", n.MaxTime)}
+  case *MaxTicksNode:
+    return []string{name, fmt.Sprintf("maxTicks=%d", n.MaxTicks)}
+  case *TimeoutNode:
+    return []string{name, fmt.Sprintf("timeout=%s completion=%s", n.Timeout, n.Completion)}
+  default:
+    return []string{name}
+  }
+}
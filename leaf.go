@@ -10,48 +10,122 @@ type PredicateLeafNode struct {
 }
 
 func (n *PredicateLeafNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+func (n *PredicateLeafNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
   if n.handler(state) {
-    n.Status = Success
+    return Success, messages
   } else {
-    n.Status = Failure
+    return Failure, messages
   }
-  return messages
 }
 
-func NewPredicateLeafNode(handler func(state interface{})bool) *PredicateLeafNode {
+func (n *PredicateLeafNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  return &c
+}
+
+// Create a new predicate node
+// The state is converted to S, like in a TypedLeafNode
+func NewPredicateLeafNode[S any](handler func(state S)bool) *PredicateLeafNode {
   n := new(PredicateLeafNode)
-  n.handler = handler
+  n.handler = func(state interface{}) bool {
+    return handler(typedValue[S](state))
+  }
   return n
 }
 
 // A leaf node that runs a handler in a goroutine
 // handler receives ticks though a channel
-// and should send over the status channel
+// and should answer each over the results channel
+// The goroutine starts on Initiate, and the ticks channel
+// is closed on Terminate
 type GoroutineLeafNode struct {
   BasicNode
-  tickChannel chan interface{}
-  statusChannel chan Status
-  handler func(<-chan interface{}, chan<- Status)
+  goroutineMemory
+  handler func(<-chan GoroutineTick, chan<- GoroutineResult)
+}
+
+// A tick as seen by the handler of a GoroutineLeafNode
+type GoroutineTick struct {
+  State interface{}
+  Messages []interface{}
+}
+
+// The answer of a handler to a tick
+// Messages replace those of the tick
+type GoroutineResult struct {
+  Status Status
+  Messages []interface{}
+}
+
+// The channels to a running handler
+type goroutineMemory struct {
+  tickChannel chan GoroutineTick
+  resultChannel chan GoroutineResult
 }
 
 func (n *GoroutineLeafNode) Initiate() {
-  n.tickChannel = make(chan interface{})
-  n.statusChannel = make(chan Status)
-  go n.handler(n.tickChannel, n.statusChannel)
+  n.InitiateAgent(new(Agent))
+}
+
+func (n *GoroutineLeafNode) InitiateAgent(a *Agent) {
+  m := agentMemory(a, n, &n.goroutineMemory)
+  m.tickChannel = make(chan GoroutineTick)
+  m.resultChannel = make(chan GoroutineResult)
+  go n.handler(m.tickChannel, m.resultChannel)
 }
 
 func (n *GoroutineLeafNode) Update(state interface{}, messages []interface{}) []interface{} {
-  // TODO send messages to goroutine
-  n.tickChannel <- state
-  n.Status = <-n.statusChannel
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
   return messages
 }
 
+func (n *GoroutineLeafNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  m := agentMemory(a, n, &n.goroutineMemory)
+  m.tickChannel <- GoroutineTick{state, messages}
+  result, ok := <-m.resultChannel
+  if !ok {
+    return Failure, messages
+  }
+  return result.Status, result.Messages
+}
+
 func (n *GoroutineLeafNode) Terminate() {
-  close(n.tickChannel)
+  n.TerminateAgent(new(Agent))
 }
 
+func (n *GoroutineLeafNode) TerminateAgent(a *Agent) {
+  close(agentMemory(a, n, &n.goroutineMemory).tickChannel)
+}
+
+func (n *GoroutineLeafNode) Clone() Node {
+  c := *n
+  c.Status = Failure
+  c.goroutineMemory = goroutineMemory{}
+  return &c
+}
+
+// Create a goroutine leaf whose handler only sees the state
+// and answers with a status, the messages are passed on
 func NewGoroutineLeafNode(handler func(<-chan interface{}, chan<- Status)) *GoroutineLeafNode {
+  return NewGoroutineMessageLeafNode(func(ticks <-chan GoroutineTick, results chan<- GoroutineResult) {
+    states := make(chan interface{})
+    statuses := make(chan Status)
+    go handler(states, statuses)
+    defer close(states)
+    for tick := range ticks {
+      states <- tick.State
+      results <- GoroutineResult{<-statuses, tick.Messages}
+    }
+  })
+}
+
+// Create a goroutine leaf whose handler also receives and returns messages
+func NewGoroutineMessageLeafNode(handler func(<-chan GoroutineTick, chan<- GoroutineResult)) *GoroutineLeafNode {
   n := new(GoroutineLeafNode)
   n.handler = handler
   return n
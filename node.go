@@ -1,12 +1,20 @@
 package behaviortree
 
 import (
+  "fmt"
   "log"
+  "math/rand"
+  "errors"
+  "strings"
+  "reflect"
+  "context"
   "runtime/debug"
 )
 
 // Represents the status of a node
-// can be one of Succes, Failure, Running
+// can be one of Succes, Failure, Running, Error
+// Error means the node could not decide, usually because it panicked
+// or the context of the tick was done
 type Status int
 
 func (s Status) String() string {
@@ -17,6 +25,8 @@ func (s Status) String() string {
     return "Success"
   case 2:
     return "Running"
+  case 3:
+    return "Error"
   default:
     return "Invalid"
   }
@@ -26,6 +36,7 @@ const (
   Failure Status = iota
   Success
   Running
+  Error
 )
 
 // The basic Node interface
@@ -43,36 +54,394 @@ type Node interface {
 // Calls Update on the node
 // Also calls Initiate and Terminate when appropriate
 func Tick(node Node, state interface{}, messages []interface{}) (status Status, newMessages []interface{}) {
+  return new(Agent).Tick(node, state, messages)
+}
+
+// Like Tick, but stops when ctx is done
+// Composites stop ticking their children and end in Error,
+// halting the children that were running
+// An Agent with a Context reports ctx.Err() from Err
+func TickContext(ctx context.Context, node Node, state interface{}, messages []interface{}) (Status, []interface{}) {
+  a := &Agent{Context: ctx}
+  return a.Tick(node, state, messages)
+}
+
+// Ticks trees on behalf of one entity
+// With a Blackboard, nodes that implement AgentNode
+// keep their status and memory in it instead of in themselves,
+// so the same tree can be ticked for many agents.
+// Without one, nodes use their own fields, just like Tick
+type Agent struct {
+  Blackboard *Blackboard
+  // Key of the tree scope in the blackboard
+  Tree interface{}
+  // Cancels the tick and carries values to the nodes, may be nil
+  // Nodes read it through Ctx
+  Context context.Context
+  // What to do when a node panics
+  Panics PanicPolicy
+  // Receives panics when Panics is ReportPanics
+  ErrorHandler func(err *TickError)
+  // Follows every node through the tick, may be nil
+  Tracer Tracer
+  // The time for time-based nodes, the wall clock when nil
+  Clock Clock
+  // Random numbers for random nodes, the global source when nil
+  // Set a seeded source for replays and tests
+  Rand *rand.Rand
+
+  // Status and runtime memory of the nodes of an Instance,
+  // by the index its Definition gave them
+  index map[Node]int
+  nodes []nodeState
+  // The nodes being ticked, from the root down
+  path []Node
+  err *TickError
+  ticks int
+}
+
+// What an Agent does when a node panics
+type PanicPolicy int
+
+const (
+  // Recover and log the panic, the node returns Error
+  RecoverPanics PanicPolicy = iota
+  // Let the panic through to the caller of Tick
+  Repanic
+  // Recover and pass the panic to the ErrorHandler, the node returns Error
+  ReportPanics
+)
+
+// A node returned Error without panicking
+var ErrErrorStatus = errors.New("node returned Error")
+
+// Why a tick ended in Error
+type TickError struct {
+  // The nodes from the ticked root down to the failing node
+  Path []Node
+  // The recovered panic value, the error of a done context,
+  // or ErrErrorStatus
+  Value interface{}
+  // Where the panic happened, if it was one
+  Stack []byte
+}
+
+func (e *TickError) Error() string {
+  return fmt.Sprintf("%s: %v", pathString(e.Path, " > "), e.Value)
+}
+
+// The type names of the nodes in path,
+// with the index of each node in its parent, like [1],
+// and the id of nodes that have one, like #id
+func pathString(path []Node, sep string) string {
+  names := make([]string, len(path))
+  for i, node := range path {
+    names[i] = fmt.Sprintf("%T", node)
+    if i > 0 {
+      if idx := childIndex(path[i-1], node); idx >= 0 {
+        names[i] += fmt.Sprintf("[%d]", idx)
+      }
+    }
+    if n, ok := node.(interface{ GetId() string }); ok && n.GetId() != "" {
+      names[i] += "#" + n.GetId()
+    }
+  }
+  return strings.Join(names, sep)
+}
+
+// The index of child among the children of parent, or -1
+func childIndex(parent Node, child Node) int {
+  p, ok := parent.(Parent)
+  if !ok || child == nil || !reflect.TypeOf(child).Comparable() {
+    return -1
+  }
+  for idx, c := range p.ChildNodes() {
+    if c == child {
+      return idx
+    }
+  }
+  return -1
+}
+
+// The panic value, if it is an error
+func (e *TickError) Unwrap() error {
+  err, _ := e.Value.(error)
+  return err
+}
+
+// The node that failed
+func (e *TickError) Node() Node {
+  return e.Path[len(e.Path)-1]
+}
+
+// A node that is ticked with the agent it runs for
+// The agent keeps the status, so UpdateAgent returns it
+// Types that embed a built-in node and override Update
+// must override UpdateAgent as well
+type AgentNode interface {
+  Node
+  UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{})
+}
+
+// Implemented by agent nodes that need the agent on Initiate,
+// otherwise Initiate is called
+type AgentInitiator interface {
+  InitiateAgent(a *Agent)
+}
+
+// Implemented by agent nodes that need the agent on Terminate,
+// otherwise Terminate is called
+type AgentTerminator interface {
+  TerminateAgent(a *Agent)
+}
+
+// Like Tick, but on behalf of this agent
+// A panic or Error status is recorded in the agent,
+// and returned by Err until the next tick of a root
+func (a *Agent) Tick(node Node, state interface{}, messages []interface{}) (status Status, newMessages []interface{}) {
+  if len(a.path) == 0 {
+    a.err = nil
+    a.ticks++
+  }
+  a.path = append(a.path, node)
+  defer func() {
+    a.path = a.path[:len(a.path)-1]
+  }()
+  // Set once Terminate is called, so a panic does not call it again
+  terminated := false
+  var previous Status
   defer func() {
-    if err := recover(); err != nil {
-      log.Printf("Error: %v\n%s", err, debug.Stack())
-      status = Failure
+    if a.Panics == Repanic {
+      return
+    }
+    if r := recover(); r != nil {
+      err := a.fail(r, debug.Stack())
+      if a.Panics == ReportPanics && a.ErrorHandler != nil {
+        a.ErrorHandler(err)
+      } else {
+        log.Printf("Error: %v\n%s", err, err.Stack)
+      }
+      status = Error
       newMessages = messages
+      a.setStatus(node, status)
+      a.trace(TraceAfterUpdate, node, previous, status, newMessages)
+      if !terminated {
+        a.terminatePanicked(node)
+        a.trace(TraceTerminate, node, previous, status, newMessages)
+      }
     }
   }()
 
-  if node.GetStatus() != Running {
-    node.Initiate()
+  if a.cancelled() {
+    terminated = true
+    a.halt(node)
+    return a.cancelledStatus(), messages
   }
+  previous = a.Status(node)
 
-  newMessages = node.Update(state, messages)
-  status = node.GetStatus()
-
+  if previous != Running {
+    a.initiate(node)
+    a.trace(TraceInitiate, node, previous, previous, messages)
+  }
+  a.trace(TraceBeforeUpdate, node, previous, previous, messages)
+  if an, ok := node.(AgentNode); ok {
+    status, newMessages = an.UpdateAgent(a, state, messages)
+    a.setStatus(node, status)
+  } else {
+    newMessages = node.Update(state, messages)
+    status = node.GetStatus()
+  }
+  a.trace(TraceAfterUpdate, node, previous, status, newMessages)
+  if status == Error {
+    a.fail(ErrErrorStatus, nil)
+  }
   if status != Running {
-    node.Terminate()
+    terminated = true
+    a.terminate(node)
+    a.trace(TraceTerminate, node, previous, status, newMessages)
   }
   return
 }
 
+// Why the last tick ended in Error, a *TickError or nil
+func (a *Agent) Err() error {
+  if a.err == nil {
+    return nil
+  }
+  return a.err
+}
+
+// Record an error at the current node
+// Only the first, deepest, error of a tick is kept
+func (a *Agent) fail(value interface{}, stack []byte) *TickError {
+  err := &TickError{Value: value, Stack: stack}
+  err.Path = append(err.Path, a.path...)
+  if a.err == nil {
+    a.err = err
+  }
+  return err
+}
+
+// A random number in [0, 1) from the source of the agent
+func (a *Agent) float64() float64 {
+  if a.Rand == nil {
+    return rand.Float64()
+  }
+  return a.Rand.Float64()
+}
+
+// A random permutation of [0, n) from the source of the agent
+func (a *Agent) perm(n int) []int {
+  if a.Rand == nil {
+    return rand.Perm(n)
+  }
+  return a.Rand.Perm(n)
+}
+
+// Where the agent keeps the status and runtime memory of node:
+// the slot of an Instance, or the blackboard
+// Nil when the node keeps them itself
+func (a *Agent) nodeState(node Node) *nodeState {
+  if _, ok := node.(AgentNode); !ok {
+    return nil
+  }
+  if idx, ok := a.index[node]; ok {
+    return &a.nodes[idx]
+  }
+  if a.Blackboard == nil {
+    return nil
+  }
+  return &a.Blackboard.node(a.Tree, node).nodeState
+}
+
+// The status of node for this agent
+func (a *Agent) Status(node Node) Status {
+  if s := a.nodeState(node); s != nil {
+    return s.status
+  }
+  return node.GetStatus()
+}
+
+// Only agent nodes keep their status in the agent,
+// other nodes are read through GetStatus
+func (a *Agent) setStatus(node Node, status Status) {
+  if s := a.nodeState(node); s != nil {
+    s.status = status
+  } else if n, ok := node.(interface{ SetStatus(Status) }); ok {
+    n.SetStatus(status)
+  }
+}
+
+// The context of the agent,
+// or the background context if it has none
+func (a *Agent) Ctx() context.Context {
+  if a.Context == nil {
+    return context.Background()
+  }
+  return a.Context
+}
+
+// Check if the context of the agent is done
+func (a *Agent) cancelled() bool {
+  return a.Ctx().Err() != nil
+}
+
+// Record why the current node stops early, and return Error
+func (a *Agent) cancelledStatus() Status {
+  a.fail(a.Ctx().Err(), nil)
+  return Error
+}
+
+// Stop node if it is running,
+// calling Terminate and resetting its status
+// Constant nodes, made by NewConstantNode, keep their status
+func (a *Agent) Halt(node Node) {
+  a.path = append(a.path, node)
+  defer func() {
+    a.path = a.path[:len(a.path)-1]
+  }()
+  a.halt(node)
+}
+
+// Like Halt, for the node at the end of the path
+func (a *Agent) halt(node Node) {
+  if _, ok := node.(*BasicNode); ok {
+    return
+  }
+  if a.Status(node) == Running {
+    a.terminate(node)
+    a.setStatus(node, Failure)
+    a.trace(TraceTerminate, node, Running, Failure, nil)
+  }
+}
+
+// Call Initiate on node the way Tick would
+func (a *Agent) initiate(node Node) {
+  if an, ok := node.(AgentInitiator); ok {
+    an.InitiateAgent(a)
+  } else {
+    node.Initiate()
+  }
+}
+
+// Call Terminate on node the way Tick would
+func (a *Agent) terminate(node Node) {
+  if an, ok := node.(AgentTerminator); ok {
+    an.TerminateAgent(a)
+  } else {
+    node.Terminate()
+  }
+}
+
+// Call Terminate on a node that panicked
+// Another panic, for example of a nil node, is only logged
+func (a *Agent) terminatePanicked(node Node) {
+  defer func() {
+    if r := recover(); r != nil {
+      log.Printf("Error: terminating %T: %v", node, r)
+    }
+  }()
+  a.terminate(node)
+}
+
+// The memory of node for this agent
+// Nil without a blackboard
+func (a *Agent) Memory(node Node) Memory {
+  if a.Blackboard == nil {
+    return nil
+  }
+  return a.Blackboard.Node(a.Tree, node)
+}
+
+// The runtime memory of a built-in node for this agent:
+// own without a blackboard or instance, or one kept by the agent
+func agentMemory[T any](a *Agent, node Node, own *T) *T {
+  n := a.nodeState(node)
+  if n == nil {
+    return own
+  }
+  if m, ok := n.state.(*T); ok {
+    return m
+  }
+  m := new(T)
+  n.state = m
+  return m
+}
+
 // A basic node with a status
+// Id is the id of the project node it was made from, if any
 type BasicNode struct {
   Status Status
+  Id string
 }
 
 func (n BasicNode) Initiate() {}
 func (n BasicNode) Update(state interface{}, messages []interface{}) []interface{} { return messages }
 func (n BasicNode) Terminate() {}
 func (n BasicNode) GetStatus() Status { return n.Status }
+func (n *BasicNode) SetStatus(status Status) { n.Status = status }
+func (n BasicNode) GetId() string { return n.Id }
+func (n *BasicNode) SetId(id string) { n.Id = id }
 
 // Create a new node that always returns the same status
 func NewConstantNode(status Status) *BasicNode {
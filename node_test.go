@@ -3,6 +3,10 @@ package behaviortree
 import (
   "testing"
   "log"
+  "errors"
+  "strings"
+  "fmt"
+  "math/rand"
   "io/ioutil"
   "encoding/json"
   "time"
@@ -48,6 +52,31 @@ func (n *PanicNode) Update(state interface{}, messages []interface{}) []interfac
   panic("welp")
 }
 
+// Runs on odd ticks and panics on even ticks
+type RunPanicNode struct {
+  BasicNode
+  Ticks int
+  Initiations int
+  Terminations int
+}
+
+func (n *RunPanicNode) Initiate() {
+  n.Initiations++
+}
+
+func (n *RunPanicNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Ticks++
+  if n.Ticks%2 == 0 {
+    panic("welp")
+  }
+  n.Status = Running
+  return messages
+}
+
+func (n *RunPanicNode) Terminate() {
+  n.Terminations++
+}
+
 
 func expectSequence(t *testing.T, node Node, statuses[]Status) {
   for idx, status := range statuses {
@@ -59,6 +88,21 @@ func expectSequence(t *testing.T, node Node, statuses[]Status) {
   }
 }
 
+// Advance the clock before a tick of node
+type clockStep struct {
+  advance time.Duration
+  status Status
+}
+
+func expectClockSequence(t *testing.T, a *Agent, clock *ManualClock, node Node, steps []clockStep) {
+  for idx, step := range steps {
+    clock.Advance(step.advance)
+    if status, _ := a.Tick(node, nil, nil); status != step.status {
+      t.Errorf("Status is %s at %s, expected %s at index %d", status, clock.Now().Sub(time.Unix(0, 0)), step.status, idx)
+    }
+  }
+}
+
 func TestConstant(t *testing.T) {
   n := NewConstantNode(Success)
   status, _ := Tick(*n, nil, nil)
@@ -70,9 +114,70 @@ func TestConstant(t *testing.T) {
 func TestPanic(t *testing.T) {
   n := new(PanicNode)
   status, _ := Tick(n, nil, nil)
-  if status != Failure {
+  if status != Error {
+    t.Errorf("Status is %s", status)
+  }
+  trees := make(map[string]Node)
+  if status, _ := Tick(trees["missing"], nil, nil); status != Error {
+    t.Errorf("Nil node status is %s", status)
+  }
+}
+
+func TestPanicTerminates(t *testing.T) {
+  n := new(RunPanicNode)
+  expectSequence(t, n, []Status{Running, Error, Running})
+  if n.Status != Running || n.Initiations != 2 || n.Terminations != 1 {
+    t.Errorf("Initiated %d times and terminated %d times", n.Initiations, n.Terminations)
+  }
+
+  n = new(RunPanicNode)
+  root := NewSequentialNode([]Node{n})
+  rec := new(recordingTracer)
+  a := &Agent{Tracer: rec}
+  a.Tick(root, nil, nil)
+  rec.events = nil
+  if status, _ := a.Tick(root, nil, nil); status != Error || a.Status(n) != Error || n.Terminations != 1 {
+    t.Errorf("Status is %s, node %s, terminated %d times", status, a.Status(n), n.Terminations)
+  }
+  if len(rec.events) != 6 || rec.events[3] != "2 Terminate 2 Error" {
+    t.Errorf("Unexpected trace %v", rec.events)
+  }
+}
+
+func TestErrorStatus(t *testing.T) {
+  n := new(PanicNode)
+  seq := NewSequentialNode([]Node{NewConstantNode(Success), NewInverterNode(n)})
+  root := NewParallelNodeAll(true, true, []Node{seq, new(HaltNode)})
+  var reported *TickError
+  a := &Agent{Panics: ReportPanics, ErrorHandler: func(err *TickError) { reported = err }}
+  status, _ := a.Tick(root, nil, nil)
+  if status != Error {
     t.Errorf("Status is %s", status)
   }
+  var err *TickError
+  if !errors.As(a.Err(), &err) || err != reported || err.Value != "welp" || len(err.Stack) == 0 {
+    t.Fatalf("Unexpected error %v", err)
+  }
+  if len(err.Path) != 4 || err.Path[0] != root || err.Node() != n {
+    t.Errorf("Unexpected path %s", err)
+  }
+
+  status, _ = a.Tick(NewWrapConstantNode(Success, NewConstantNode(Error)), nil, nil)
+  if status != Error || !errors.Is(a.Err(), ErrErrorStatus) {
+    t.Errorf("Error status not reported: %s %v", status, a.Err())
+  }
+  a.Tick(NewConstantNode(Success), nil, nil)
+  if a.Err() != nil {
+    t.Errorf("Error not cleared: %v", a.Err())
+  }
+
+  defer func() {
+    if recover() == nil {
+      t.Errorf("Panic was recovered")
+    }
+  }()
+  a.Panics = Repanic
+  a.Tick(seq, nil, nil)
 }
 
 func TestArrayLeaf(t *testing.T) {
@@ -99,6 +204,127 @@ func TestGoroutineLeaf(t *testing.T) {
   expectSequence(t, n, seq)
 }
 
+// Remembers the kinds and statuses of the events it sees
+type recordingTracer struct {
+  events []string
+}
+
+func (r *recordingTracer) Trace(e *TraceEvent) {
+  r.events = append(r.events, fmt.Sprintf("%d %s %d %s", e.Tick, e.Kind, len(e.Path), e.Status))
+}
+
+func TestTracer(t *testing.T) {
+  halt := new(HaltNode)
+  n := NewSelectorNode([]Node{NewArrayLeafNode(t, "trace", []Status{Failure, Success}), halt})
+  rec := new(recordingTracer)
+  a := &Agent{Tracer: rec}
+  a.Tick(n, nil, nil)
+  a.Tick(n, nil, nil)
+  expected := []string{
+    "1 Initiate 1 Failure", "1 BeforeUpdate 1 Failure",
+    "1 Initiate 2 Failure", "1 BeforeUpdate 2 Failure", "1 AfterUpdate 2 Failure", "1 Terminate 2 Failure",
+    "1 Initiate 2 Failure", "1 BeforeUpdate 2 Failure", "1 AfterUpdate 2 Running",
+    "1 AfterUpdate 1 Running",
+    "2 BeforeUpdate 1 Running",
+    "2 Initiate 2 Failure", "2 BeforeUpdate 2 Failure", "2 AfterUpdate 2 Success", "2 Terminate 2 Success",
+    "2 Terminate 2 Failure",
+    "2 AfterUpdate 1 Success", "2 Terminate 1 Success",
+  }
+  if strings.Join(rec.events, "\n") != strings.Join(expected, "\n") {
+    t.Errorf("Unexpected events\n%s", strings.Join(rec.events, "\n"))
+  }
+
+  var buf strings.Builder
+  a = &Agent{Tracer: NewLogTracer(&buf)}
+  a.Tick(NewInverterNode(NewConstantNode(Success)), nil, []interface{}{"msg"})
+  line := "tick=1 event=AfterUpdate path=*behaviortree.InverterNode/*behaviortree.BasicNode[0] previous=Success status=Success messages=1"
+  if !strings.Contains(buf.String(), line) {
+    t.Errorf("Unexpected log\n%s", buf.String())
+  }
+
+  buf.Reset()
+  children := []Node{NewConstantNode(Failure), NewConstantNode(Failure), NewConstantNode(Success)}
+  children[2].(*BasicNode).Id = "c"
+  a.Tick(NewSelectorNode(children), nil, nil)
+  line = "tick=2 event=AfterUpdate path=*behaviortree.SelectorNode/*behaviortree.BasicNode[2]#c previous=Success status=Success"
+  if !strings.Contains(buf.String(), line) || !strings.Contains(buf.String(), "path=*behaviortree.SelectorNode/*behaviortree.BasicNode[1] ") {
+    t.Errorf("Unexpected log\n%s", buf.String())
+  }
+}
+
+// Tick until the status is not Running, or give up after a second
+func tickUntilDone(t *testing.T, a *Agent, node Node, messages []interface{}) (Status, []interface{}) {
+  deadline := time.Now().Add(time.Second)
+  for time.Now().Before(deadline) {
+    status, out := a.Tick(node, nil, messages)
+    if status != Running {
+      return status, out
+    }
+    time.Sleep(time.Millisecond)
+  }
+  t.Fatalf("Node still running")
+  return Running, nil
+}
+
+func TestAsyncLeaf(t *testing.T) {
+  release := make(chan struct{})
+  received := make(chan []interface{}, 1)
+  n := NewAsyncLeafNode(func(task *AsyncTask) Status {
+    task.Send("started")
+    <-release
+    received <- task.Receive()
+    task.Send("done")
+    return Success
+  })
+  a := new(Agent)
+  status, messages := a.Tick(n, nil, []interface{}{"hello"})
+  if status != Running {
+    t.Errorf("Status is %s", status)
+  }
+  for len(messages) < 2 {
+    status, messages = a.Tick(n, nil, []interface{}{"hello"})
+  }
+  if status != Running || messages[1] != "started" {
+    t.Errorf("Unexpected %s %v", status, messages)
+  }
+  close(release)
+  status, messages = tickUntilDone(t, a, n, nil)
+  if status != Success || len(messages) != 1 || messages[0] != "done" {
+    t.Errorf("Unexpected %s %v", status, messages)
+  }
+  if in := <-received; len(in) < 2 || in[0] != "hello" {
+    t.Errorf("Work received %v", in)
+  }
+
+  cancelled := make(chan struct{})
+  n = NewAsyncLeafNode(func(task *AsyncTask) Status {
+    <-task.Context.Done()
+    close(cancelled)
+    return Failure
+  })
+  a.Tick(n, nil, nil)
+  a.Halt(n)
+  select {
+  case <-cancelled:
+  case <-time.After(time.Second):
+    t.Errorf("Work not cancelled")
+  }
+
+  n = NewAsyncLeafNode(func(task *AsyncTask) Status {
+    panic("async")
+  })
+  if status, _ := tickUntilDone(t, a, n, nil); status != Error {
+    t.Errorf("Status is %s", status)
+  }
+
+  n = NewAsyncLeafNode(func(task *AsyncTask) Status {
+    return Running
+  })
+  if status, _ := tickUntilDone(t, a, n, nil); status != Error || !errors.Is(a.Err(), ErrAsyncRunning) {
+    t.Errorf("Status is %s, error %v", status, a.Err())
+  }
+}
+
 func TestSelector(t *testing.T) {
   seq := []Status{Success, Failure}
   ch := []Node{
@@ -123,6 +349,98 @@ func TestSequential(t *testing.T) {
   expectSequence(t, n, expected)
 }
 
+// A leaf that keeps running and counts how often it is halted
+type HaltNode struct {
+  BasicNode
+  Halts int
+  Initiations int
+}
+
+func (n *HaltNode) Initiate() {
+  n.Initiations++
+}
+
+func (n *HaltNode) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status = Running
+  return messages
+}
+
+func (n *HaltNode) Terminate() {
+  n.Halts++
+}
+
+func TestHalt(t *testing.T) {
+  halt := new(HaltNode)
+  sel := NewSelectorNode([]Node{NewArrayLeafNode(t, "sel", []Status{Failure, Success}), halt})
+  expectSequence(t, sel, []Status{Running, Success})
+  if halt.Halts != 1 || halt.Status == Running {
+    t.Errorf("Selector did not halt its running child")
+  }
+
+  halt = new(HaltNode)
+  seq := NewSequentialNode([]Node{NewArrayLeafNode(t, "seq", []Status{Success, Running}), halt})
+  expectSequence(t, seq, []Status{Running, Running})
+  if halt.Halts != 1 {
+    t.Errorf("Sequence did not halt its running child")
+  }
+
+  halt = new(HaltNode)
+  par := NewParallelNodeAll(false, true, []Node{NewArrayLeafNode(t, "par", []Status{Running, Success}), halt})
+  expectSequence(t, par, []Status{Running, Success})
+  if halt.Halts != 1 {
+    t.Errorf("Parallel did not halt its running child")
+  }
+
+  halt = new(HaltNode)
+  timeout := NewTimeoutNode(time.Millisecond, Success, halt)
+  expectSequence(t, timeout, []Status{Running})
+  time.Sleep(2 * time.Millisecond)
+  expectSequence(t, timeout, []Status{Success})
+  if halt.Halts != 1 {
+    t.Errorf("Timeout did not halt its running child")
+  }
+
+  halt = new(HaltNode)
+  inst := NewDefinition(NewSelectorNode([]Node{NewArrayLeafNode(t, "inst", []Status{Failure, Success, Failure}), halt})).Instantiate()
+  for idx, expected := range []Status{Running, Success, Running} {
+    if status, _ := inst.Tick(nil, nil); status != expected {
+      t.Errorf("Status is %s, expected %s at index %d", status, expected, idx)
+    }
+  }
+  if halt.Halts != 1 || halt.Initiations != 2 {
+    t.Errorf("Instance halted %d times and initiated %d times", halt.Halts, halt.Initiations)
+  }
+}
+
+func TestReactive(t *testing.T) {
+  halt := new(HaltNode)
+  seq := NewReactiveSequenceNode([]Node{NewArrayLeafNode(t, "cond", []Status{Success, Success, Failure}), halt})
+  expectSequence(t, seq, []Status{Running, Running, Failure})
+  if halt.Halts != 1 || halt.Status == Running {
+    t.Errorf("Reactive sequence did not preempt its running child")
+  }
+
+  halt = new(HaltNode)
+  fallback := NewReactiveFallbackNode([]Node{NewArrayLeafNode(t, "cond", []Status{Failure, Success}), halt})
+  expectSequence(t, fallback, []Status{Running, Success})
+  if halt.Halts != 1 {
+    t.Errorf("Reactive fallback did not preempt its running child")
+  }
+
+  nodes := map[string]ProjectNode{
+    "a": {Id: "a", Name: "ReactiveFallback", Children: []string{"b", "c"}},
+    "b": {Id: "b", Name: "ReactiveSequence", Children: []string{"c"}},
+    "c": {Id: "c", Name: "Failer"},
+  }
+  n, err := MakeNode("a", nodes)
+  if err != nil {
+    t.Fatalf("MakeNode failed: %s", err)
+  }
+  if _, ok := n.(*ReactiveFallbackNode).Children[0].(*ReactiveSequenceNode); !ok {
+    t.Errorf("Unexpected nodes %+v", n)
+  }
+}
+
 func TestParallel(t *testing.T) {
   ch := []Node{
     NewArrayLeafNode(t, "par 1", []Status{Success, Failure}),
@@ -214,6 +532,22 @@ func TestTimeout(t *testing.T) {
   expectSequence(t, n, expected)
 }
 
+func TestManualClock(t *testing.T) {
+  clock := NewManualClock(time.Unix(0, 0))
+  n := NewTimeoutNode(time.Hour, Success, NewConstantNode(Running))
+  a := &Agent{Clock: clock}
+  expectClockSequence(t, a, clock, n, []clockStep{{0, Running}, {59*time.Minute, Running}, {time.Minute, Success}, {0, Running}})
+
+  def := NewDefinition(n)
+  def.Clock = clock
+  inst := def.Instantiate()
+  inst.Tick(nil, nil)
+  clock.Advance(time.Hour)
+  if status, _ := inst.Tick(nil, nil); status != Success {
+    t.Errorf("Instance did not use the clock of its definition: %s", status)
+  }
+}
+
 func TestMarshal(t *testing.T) {
   seq := []Status{Running}
   ch := []Node{
@@ -242,3 +576,425 @@ func TestMarshal(t *testing.T) {
     t.Errorf("Unexpected status: %s", n.Status)
 	}
 }
+
+// A tree of some 60 nodes that are all reached within 3 ticks
+func benchmarkTree() Node {
+  branches := make([]Node, 5)
+  for i := range branches {
+    branches[i] = NewSequentialNode([]Node{
+      NewInverterNode(NewConstantNode(Failure)),
+      NewRepeaterNode(3, NewConstantNode(Success)),
+      NewParallelNodeAll(false, false, []Node{
+        NewConstantNode(Success),
+        NewSequentialMemoryNode([]Node{NewConstantNode(Success), NewConstantNode(Running)}),
+      }),
+      NewSelectorMemoryNode([]Node{NewConstantNode(Failure), NewConstantNode(Running)}),
+    })
+  }
+  return NewParallelNodeAll(true, true, branches)
+}
+
+// Copy the tree for every agent, like ticking without instances requires
+func BenchmarkCloneTreePerAgent(b *testing.B) {
+  root := benchmarkTree()
+  b.ReportAllocs()
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    tree := CloneTree(root)
+    for tick := 0; tick < 4; tick++ {
+      Tick(tree, nil, nil)
+    }
+  }
+}
+
+// Build the tree once and instantiate it for every agent
+func BenchmarkInstantiatePerAgent(b *testing.B) {
+  def := NewDefinition(benchmarkTree())
+  b.ReportAllocs()
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    inst := def.Instantiate()
+    for tick := 0; tick < 4; tick++ {
+      inst.Tick(nil, nil)
+    }
+  }
+}
+
+func TestInstances(t *testing.T) {
+  even := NewPredicateLeafNode(func(state interface{}) bool {
+    return state.(int) % 2 == 0
+  })
+  seq := NewSequentialMemoryNode([]Node{NewRepeatUntilNode(Success, even), NewConstantNode(Success)})
+  def := NewDefinition(seq)
+  a := def.Instantiate()
+  b := def.Instantiate()
+
+  for idx, state := range []int{1, 3, 4} {
+    expected := []Status{Running, Running, Success}[idx]
+    if status, _ := a.Tick(state, nil); status != expected {
+      t.Errorf("Status is %s, expected %s at index %d", status, expected, idx)
+    }
+  }
+  if status, _ := b.Tick(1, nil); status != Running {
+    t.Errorf("Second instance status is %s", status)
+  }
+  if a.Status(seq) != Success || b.Status(seq) != Running || seq.GetStatus() != Failure {
+    t.Errorf("Statuses are %s, %s and %s", a.Status(seq), b.Status(seq), seq.GetStatus())
+  }
+  if len(a.Agent.Blackboard.trees) != 0 {
+    t.Errorf("Instance kept its nodes in the blackboard")
+  }
+
+  shared := NewRepeaterNode(2, NewConstantNode(Success))
+  inst := NewDefinition(NewSequentialNode([]Node{shared, NewInverterNode(shared)})).Instantiate()
+  if len(inst.Agent.nodes) != 3 {
+    t.Errorf("Shared node indexed %d times", len(inst.Agent.nodes) - 2)
+  }
+}
+
+// A selector that says so when updated
+type LoudSelectorNode struct {
+  SelectorNode
+  Name string
+}
+
+func TestCloneTree(t *testing.T) {
+  leaf := NewArrayLeafNode(t, "clone", []Status{Running, Success})
+  rep := NewRepeaterNode(2, NewArrayLeafNode(t, "clone rep", []Status{Success}))
+  loud := &LoudSelectorNode{Name: "loud"}
+  loud.Children = []Node{NewConstantNode(Failure)}
+  n := NewSequentialMemoryNode([]Node{leaf, rep, loud})
+  expectSequence(t, n, []Status{Running})
+
+  c := CloneTree(n).(*SequentialMemoryNode)
+  if c == n || c.Children[0] == leaf || c.Children[1] == rep {
+    t.Fatalf("Clone shares nodes")
+  }
+  if c.Children[0].(*ArrayLeafNode).Counter != 1 || c.Status != Failure {
+    t.Errorf("Unexpected clone state: %+v", c)
+  }
+  if cl, ok := c.Children[2].(*LoudSelectorNode); !ok || cl.Name != "loud" || cl.Children[0] == loud.Children[0] {
+    t.Errorf("Custom node not cloned: %#v", c.Children[2])
+  }
+  expectSequence(t, c, []Status{Running, Failure})
+  if leaf.Counter != 1 || rep.Counter != 0 {
+    t.Errorf("Ticking the clone changed the original")
+  }
+}
+
+func TestGraph(t *testing.T) {
+  leaf := NewArrayLeafNode(t, "graph", []Status{Running})
+  n := NewSequentialNode([]Node{
+    NewParallelNodeBounded(1, 2, []Node{leaf, NewRepeaterNode(3, leaf)}),
+    NewTimeoutNode(time.Second, Failure, NewConstantNode(Success)),
+  })
+  Tick(n, nil, nil)
+
+  var buf strings.Builder
+  g := &Graph{Status: true}
+  if err := g.WriteDOT(&buf, n); err != nil {
+    t.Fatalf("WriteDOT failed: %s", err)
+  }
+  t.Log(buf.String())
+  for _, line := range []string{
+    `n0 [label="SequentialNode", style=filled, fillcolor="#f9e08b"];`,
+    `n1 [label="ParallelNode\nminSuccess=1 minFail=2", style=filled, fillcolor="#f9e08b"];`,
+    `n1 -> n2;`, `n3 -> n2;`,
+    `[label="TimeoutNode\ntimeout=1s completion=Failure", style=filled, fillcolor="#f4a6a6"];`,
+  } {
+    if !strings.Contains(buf.String(), line) {
+      t.Errorf("DOT lacks %s", line)
+    }
+  }
+
+  buf.Reset()
+  if err := WriteMermaid(&buf, n); err != nil {
+    t.Fatalf("WriteMermaid failed: %s", err)
+  }
+  t.Log(buf.String())
+  for _, line := range []string{
+    "graph TD\n", `n3["RepeaterNode<br/>limit=3"]`, "n0 --> n4\n",
+  } {
+    if !strings.Contains(buf.String(), line) {
+      t.Errorf("Mermaid lacks %s", line)
+    }
+  }
+  if strings.Contains(buf.String(), "style") {
+    t.Errorf("Mermaid colored without Status")
+  }
+}
+
+func TestWalk(t *testing.T) {
+  nodes := map[string]ProjectNode{
+    "a": {Id: "a", Name: "Sequence", Children: []string{"b", "d"}},
+    "b": {Id: "b", Name: "Inverter", Child: "c"},
+    "c": {Id: "c", Name: "Failer"},
+    "d": {Id: "d", Name: "Repeat", Child: "e", Properties: map[string]interface{}{"limit": 2.0}},
+    "e": {Id: "e", Name: "Succeeder"},
+  }
+  root, err := MakeNode("a", nodes)
+  if err != nil {
+    t.Fatalf("MakeNode failed: %s", err)
+  }
+
+  var visited []string
+  err = Walk(root, func(path []Node, node Node) error {
+    visited = append(visited, fmt.Sprintf("%d %s", len(path), node.(interface{ GetId() string }).GetId()))
+    if _, ok := node.(*InverterNode); ok {
+      return SkipChildren
+    }
+    return nil
+  })
+  if err != nil || strings.Join(visited, ",") != "1 a,2 b,2 d,3 e" {
+    t.Errorf("Unexpected walk %v: %v", visited, err)
+  }
+  stop := errors.New("stop")
+  if err := Walk(root, func(path []Node, node Node) error { return stop }); err != stop {
+    t.Errorf("Walk did not stop: %v", err)
+  }
+
+  if n, ok := FindId(root, "d").(*RepeaterNode); !ok || n.Limit != 2 {
+    t.Errorf("Found %+v", n)
+  }
+  if FindId(root, "x") != nil {
+    t.Errorf("Found a missing id")
+  }
+  if leaves := FindAll[*BasicNode](root); len(leaves) != 2 || leaves[0].Id != "c" {
+    t.Errorf("Found %+v", leaves)
+  }
+
+  pr, err := EncodeTree("walk", root)
+  if err != nil {
+    t.Fatalf("Encode failed: %s", err)
+  }
+  if tree := pr.Data.Trees[0]; tree.Root != "a" || tree.Nodes["d"].Child != "e" {
+    t.Errorf("Ids not preserved: %+v", tree)
+  }
+}
+
+func TestRetry(t *testing.T) {
+  clock := NewManualClock(time.Unix(0, 0))
+  var buf strings.Builder
+  a := &Agent{Clock: clock, Tracer: NewLogTracer(&buf)}
+  n := NewRetryNode(5, time.Second, NewArrayLeafNode(t, "retry", []Status{Failure, Failure, Success}))
+  expectClockSequence(t, a, clock, n, []clockStep{{0, Running}, {500*time.Millisecond, Running}, {500*time.Millisecond, Running}, {1900*time.Millisecond, Running}, {100*time.Millisecond, Success}})
+  if !strings.Contains(buf.String(), "tick=3 event=Retry path=*behaviortree.RetryNode previous=Running status=Running messages=0 attempt=2") {
+    t.Errorf("Attempt not traced\n%s", buf.String())
+  }
+
+  n = NewRetryNode(2, time.Second, NewConstantNode(Failure))
+  n.Jitter = 0.5
+  a.Clock = clock
+  expectStatus := func(expected Status) {
+    if status, _ := a.Tick(n, nil, nil); status != expected {
+      t.Errorf("Status is %s, expected %s", status, expected)
+    }
+  }
+  expectStatus(Running)
+  if wait := n.Next.Sub(clock.Now()); wait < 500*time.Millisecond || wait > 1500*time.Millisecond {
+    t.Errorf("Wait %s outside jitter", wait)
+  }
+  clock.Advance(1500*time.Millisecond)
+  expectStatus(Failure)
+}
+
+func TestCooldown(t *testing.T) {
+  clock := NewManualClock(time.Unix(0, 0))
+  def := NewDefinition(NewCooldownNode(time.Second, NewConstantNode(Success)))
+  def.Clock = clock
+  first, second := def.Instantiate(), def.Instantiate()
+  expectClockSequence(t, &first.Agent, clock, def.Root, []clockStep{{0, Success}, {999*time.Millisecond, Failure}})
+  expectClockSequence(t, &second.Agent, clock, def.Root, []clockStep{{0, Success}})
+  expectClockSequence(t, &first.Agent, clock, def.Root, []clockStep{{time.Millisecond, Success}})
+  expectClockSequence(t, &second.Agent, clock, def.Root, []clockStep{{0, Failure}})
+}
+
+func TestRateLimit(t *testing.T) {
+  clock := NewManualClock(time.Unix(0, 0))
+  a := &Agent{Clock: clock}
+  leaf := NewArrayLeafNode(t, "limited", []Status{Success, Running, Success})
+  n := NewRateLimitNode(2, time.Second, leaf)
+  expectClockSequence(t, a, clock, n, []clockStep{{0, Success}, {0, Running}, {0, Success}, {500*time.Millisecond, Failure}, {500*time.Millisecond, Success}})
+  if leaf.Counter != 4 {
+    t.Errorf("Child ticked %d times", leaf.Counter)
+  }
+}
+
+func TestMaxTime(t *testing.T) {
+  clock := NewManualClock(time.Unix(0, 0))
+  a := &Agent{Clock: clock}
+  halt := new(HaltNode)
+  n := NewMaxTimeNode(time.Second, halt)
+  expectClockSequence(t, a, clock, n, []clockStep{{0, Running}, {999*time.Millisecond, Running}, {time.Millisecond, Failure}, {0, Running}})
+  if halt.Halts != 1 {
+    t.Errorf("Child halted %d times", halt.Halts)
+  }
+  done := NewMaxTimeNode(time.Second, NewArrayLeafNode(t, "max", []Status{Running, Success}))
+  clock.Advance(time.Hour)
+  if status, _ := a.Tick(done, nil, nil); status != Running {
+    t.Errorf("Status is %s", status)
+  }
+  clock.Advance(time.Hour)
+  if status, _ := a.Tick(done, nil, nil); status != Success {
+    t.Errorf("Completed child failed: %s", status)
+  }
+
+  halt = new(HaltNode)
+  expectSequence(t, NewMaxTicksNode(3, halt), []Status{Running, Running, Failure, Running})
+  if halt.Halts != 1 {
+    t.Errorf("Child halted %d times", halt.Halts)
+  }
+}
+
+// A leaf that records its name when ticked
+type OrderNode struct {
+  BasicNode
+  Name string
+  Order *[]string
+}
+
+func (n *OrderNode) Update(state interface{}, messages []interface{}) []interface{} {
+  *n.Order = append(*n.Order, n.Name)
+  return messages
+}
+
+func orderNodes(order *[]string, status Status, names ...string) []Node {
+  nodes := make([]Node, len(names))
+  for idx, name := range names {
+    nodes[idx] = &OrderNode{BasicNode{Status: status}, name, order}
+  }
+  return nodes
+}
+
+func TestRandom(t *testing.T) {
+  var order []string
+  sel := NewRandomSelectorNode(orderNodes(&order, Failure, "a", "b", "c", "d"))
+  orders := make(map[string]bool)
+  for i := 0; i < 2; i++ {
+    a := &Agent{Rand: rand.New(rand.NewSource(1))}
+    order = nil
+    if status, _ := a.Tick(sel, nil, nil); status != Failure {
+      t.Errorf("Status is %s", status)
+    }
+    orders[strings.Join(order, "")] = true
+  }
+  if len(orders) != 1 || len(order) != 4 {
+    t.Errorf("Orders differ with the same seed: %v", orders)
+  }
+
+  a := &Agent{Rand: rand.New(rand.NewSource(2))}
+  seq := NewRandomSequenceNode(append(orderNodes(&order, Success, "a", "b"), new(HaltNode)))
+  order = nil
+  a.Tick(seq, nil, nil)
+  a.Tick(seq, nil, nil)
+  if status := a.Status(seq); status != Running || len(order) > 2 {
+    t.Errorf("Random sequence did not resume: %s %v", status, order)
+  }
+
+  first := make(map[string]int)
+  weighted := NewWeightedSelectorNode([]float64{1, 0, 3}, orderNodes(&order, Success, "a", "b", "c"))
+  for i := 0; i < 1000; i++ {
+    order = nil
+    a.Tick(weighted, nil, nil)
+    first[order[0]]++
+  }
+  if first["b"] != 0 || first["c"] < 650 || first["c"] > 850 {
+    t.Errorf("Unexpected choices %v", first)
+  }
+
+  for _, weights := range []interface{}{"1, 0, 3", []interface{}{1.0, 0.0, 3.0}} {
+    nodes := map[string]ProjectNode{
+      "a": {Id: "a", Name: "WeightedSelector", Children: []string{"b", "c", "d"}, Properties: map[string]interface{}{"weights": weights}},
+      "b": {Id: "b", Name: "Failer"},
+      "c": {Id: "c", Name: "Failer"},
+      "d": {Id: "d", Name: "Succeeder"},
+    }
+    root, err := MakeNode("a", nodes)
+    if err != nil {
+      t.Fatalf("MakeNode failed: %s", err)
+    }
+    if w := root.(*WeightedSelectorNode).Weights; len(w) != 3 || w[2] != 3 {
+      t.Errorf("Unexpected weights %v", w)
+    }
+    expectSequence(t, root, []Status{Success})
+  }
+  nodes := map[string]ProjectNode{
+    "a": {Id: "a", Name: "WeightedSelector", Children: []string{"b"}, Properties: map[string]interface{}{"weights": "1, 2"}},
+    "b": {Id: "b", Name: "Failer"},
+  }
+  if _, err := MakeNode("a", nodes); !errors.Is(err, ErrPropertyValue) {
+    t.Errorf("Expected ErrPropertyValue, got %v", err)
+  }
+}
+
+// A constant node with a fixed score
+type ScoredNode struct {
+  BasicNode
+  score float64
+}
+
+func (n *ScoredNode) Score(state interface{}) float64 {
+  return n.score
+}
+
+func TestUtilitySelector(t *testing.T) {
+  score := func(key string) ScoreFunc {
+    return func(state interface{}) float64 {
+      return state.(map[string]float64)[key]
+    }
+  }
+  eat, flee := new(HaltNode), new(HaltNode)
+  n := NewUtilitySelectorNode([]ScoreFunc{score("eat"), score("flee")}, []Node{eat, flee})
+  n.Hysteresis = 0.1
+  a := new(Agent)
+  for _, step := range []struct {
+    state map[string]float64
+    eat Status
+    flee Status
+  }{
+    {map[string]float64{"eat": 1, "flee": 0}, Running, Failure},
+    {map[string]float64{"eat": 1, "flee": 1.05}, Running, Failure},
+    {map[string]float64{"eat": 1, "flee": 1.2}, Failure, Running},
+  } {
+    a.Tick(n, step.state, nil)
+    if eat.Status != step.eat || flee.Status != step.flee {
+      t.Errorf("Unexpected choice at %v: %s %s", step.state, eat.Status, flee.Status)
+    }
+  }
+  if eat.Halts != 1 {
+    t.Errorf("Preempted child halted %d times", eat.Halts)
+  }
+
+  children := []Node{&ScoredNode{BasicNode{Status: Failure}, 2}, &ScoredNode{BasicNode{Status: Success}, 1}}
+  n = NewUtilitySelectorNode(nil, children)
+  expectSequence(t, n, []Status{Failure})
+  n.FallThrough = true
+  expectSequence(t, n, []Status{Success})
+
+  reg := DefaultRegistry()
+  reg.RegisterScore("hunger", func(state interface{}) float64 { return 1 })
+  nodes := map[string]ProjectNode{
+    "a": {Id: "a", Name: "UtilitySelector", Children: []string{"b", "c"}, Properties: map[string]interface{}{
+      "scores": []interface{}{"", "hunger"}, "hysteresis": 0.5, "fallThrough": true,
+    }},
+    "b": {Id: "b", Name: "Failer"},
+    "c": {Id: "c", Name: "Succeeder"},
+  }
+  root, err := reg.MakeNode("a", nodes)
+  if err != nil {
+    t.Fatalf("MakeNode failed: %s", err)
+  }
+  if u := root.(*UtilitySelectorNode); u.Scores[1] == nil || u.Hysteresis != 0.5 || !u.FallThrough {
+    t.Errorf("Unexpected utility selector %+v", u)
+  }
+  pr, err := EncodeTree("utility", root)
+  if err != nil {
+    t.Fatalf("Encode failed: %s", err)
+  }
+  if err := reg.MakeTrees(pr, make(map[string]Node)); err != nil {
+    t.Errorf("Encoded tree does not decode: %s", err)
+  }
+  if _, err := MakeNode("a", nodes); !errors.Is(err, ErrPropertyValue) {
+    t.Errorf("Expected ErrPropertyValue for an unknown score, got %v", err)
+  }
+}
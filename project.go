@@ -0,0 +1,276 @@
+package behaviortree
+
+import (
+  "io"
+  "sort"
+  "errors"
+  "encoding/json"
+)
+
+// The shapes in which behavior3editor writes projects
+type ProjectFormat int
+
+const (
+  // A saved project with a name and its data
+  ProjectFile ProjectFormat = iota
+  // The project data only, as written by "Export project"
+  ProjectExport
+  // A single tree, as written by "Export tree"
+  TreeExport
+)
+
+// A behavior3editor project
+// Format records the shape it was read in,
+// so WriteProject can write it back the same way
+type Project struct {
+  Name string `json:"name"`
+  Description string `json:"description,omitempty"`
+  Path string `json:"path,omitempty"`
+  Data ProjectData `json:"data"`
+  Format ProjectFormat `json:"-"`
+}
+
+type ProjectData struct {
+  Version string `json:"version,omitempty"`
+  Scope string `json:"scope,omitempty"`
+  SelectedTree string `json:"selectedTree,omitempty"`
+  Trees []ProjectTree `json:"trees"`
+  CustomNodes []CustomNode `json:"custom_nodes,omitempty"`
+}
+
+type ProjectTree struct {
+  Version string `json:"version,omitempty"`
+  Scope string `json:"scope,omitempty"`
+  Id string `json:"id"`
+  Title string `json:"title"`
+  Description string `json:"description"`
+  Root string `json:"root"`
+  Properties map[string]interface{} `json:"properties"`
+  Nodes map[string] ProjectNode `json:"nodes"`
+  Display *TreeDisplay `json:"display,omitempty"`
+  // Only present in tree exports
+  CustomNodes []CustomNode `json:"custom_nodes,omitempty"`
+}
+
+// Where the editor camera and root were for a tree
+type TreeDisplay struct {
+  CameraX float64 `json:"camera_x"`
+  CameraY float64 `json:"camera_y"`
+  CameraZ float64 `json:"camera_z"`
+  X float64 `json:"x"`
+  Y float64 `json:"y"`
+}
+
+type ProjectNode struct {
+  Id string `json:"id"`
+  Name string `json:"name"`
+  Title string `json:"title"`
+  Description string `json:"description"`
+  Properties map[string]interface{} `json:"properties"`
+  // Used by editor versions before 0.3
+  Parameters map[string]interface{} `json:"parameters,omitempty"`
+  Display *NodeDisplay `json:"display,omitempty"`
+  Child string `json:"child,omitempty"`
+  Children []string `json:"children,omitempty"`
+}
+
+// Where a node is drawn in the editor
+type NodeDisplay struct {
+  X float64 `json:"x"`
+  Y float64 `json:"y"`
+}
+
+// A node type defined in the editor
+// Category is one of composite, decorator, action or condition
+// and Properties holds the declared properties with their defaults
+type CustomNode struct {
+  Version string `json:"version,omitempty"`
+  Scope string `json:"scope,omitempty"`
+  Name string `json:"name"`
+  Category string `json:"category"`
+  Title string `json:"title"`
+  Description string `json:"description"`
+  Properties map[string]interface{} `json:"properties"`
+  // Used by editor versions before 0.3
+  Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+var (
+  // A node does not have the children its category requires
+  ErrNodeCategory = errors.New("children do not match category")
+  // A node has a property its custom node does not declare
+  ErrUnknownProperty = errors.New("undeclared property")
+)
+
+// Read a project in any of the ProjectFormats
+func ReadProject(file io.Reader) (*Project, error) {
+  var raw json.RawMessage
+  dec := json.NewDecoder(file)
+  err := dec.Decode(&raw)
+  if err != nil {
+    return nil, err
+  }
+  var shape struct {
+    Scope string
+    Data json.RawMessage
+    Root *string
+  }
+  if err := json.Unmarshal(raw, &shape); err != nil {
+    return nil, err
+  }
+
+  var pr Project
+  switch {
+  case shape.Data != nil:
+    pr.Format = ProjectFile
+    err = json.Unmarshal(raw, &pr)
+  case shape.Scope == "tree" || shape.Root != nil:
+    var tree ProjectTree
+    pr.Format = TreeExport
+    err = json.Unmarshal(raw, &tree)
+    pr.Name = tree.Title
+    pr.Data.Version = tree.Version
+    pr.Data.Trees = []ProjectTree{tree}
+  default:
+    pr.Format = ProjectExport
+    err = json.Unmarshal(raw, &pr.Data)
+  }
+  if err != nil {
+    return nil, err
+  }
+  return &pr, nil
+}
+
+// Write a project as JSON in its Format
+// A TreeExport writes only the first tree
+func WriteProject(file io.Writer, pr *Project) error {
+  var v interface{}
+  switch pr.Format {
+  case ProjectExport:
+    v = pr.Data
+  case TreeExport:
+    if len(pr.Data.Trees) == 0 {
+      return errors.New("tree export without a tree")
+    }
+    v = pr.Data.Trees[0]
+  default:
+    v = pr
+  }
+  enc := json.NewEncoder(file)
+  enc.SetIndent("", "  ")
+  return enc.Encode(v)
+}
+
+// Find a custom node definition by name
+// Definitions in the project take precedence over those of a tree
+func (pr *Project) CustomNode(name string) (*CustomNode, bool) {
+  for idx := range pr.Data.CustomNodes {
+    if pr.Data.CustomNodes[idx].Name == name {
+      return &pr.Data.CustomNodes[idx], true
+    }
+  }
+  for tidx := range pr.Data.Trees {
+    tree := &pr.Data.Trees[tidx]
+    for idx := range tree.CustomNodes {
+      if tree.CustomNodes[idx].Name == name {
+        return &tree.CustomNodes[idx], true
+      }
+    }
+  }
+  return nil, false
+}
+
+// Check every node that uses a custom node definition
+// against the declared category, properties and parameters
+// All problems are returned together as an ErrorList
+func (pr *Project) Validate() error {
+  var errs ErrorList
+  for _, tree := range pr.Data.Trees {
+    ids := make([]string, 0, len(tree.Nodes))
+    for id := range tree.Nodes {
+      ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    for _, id := range ids {
+      node := tree.Nodes[id]
+      custom, ok := pr.CustomNode(node.Name)
+      if !ok {
+        continue
+      }
+      var nodeErrs ErrorList
+      nodeErrs = nodeErrs.add(node, custom.checkCategory(node))
+      nodeErrs = nodeErrs.add(node, checkDeclared(node, node.Properties, custom.Properties))
+      nodeErrs = nodeErrs.add(node, checkDeclared(node, node.Parameters, custom.Parameters))
+      for _, err := range nodeErrs {
+        err.Tree = tree.Title
+      }
+      errs = append(errs, nodeErrs...)
+    }
+  }
+  return errs.err()
+}
+
+// Check that node has the children its category asks for
+func (c *CustomNode) checkCategory(node ProjectNode) error {
+  var ok bool
+  switch c.Category {
+  case "composite":
+    ok = node.Child == ""
+  case "decorator":
+    ok = node.Child != "" && len(node.Children) == 0
+  case "action", "condition":
+    ok = node.Child == "" && len(node.Children) == 0
+  default:
+    ok = true
+  }
+  if !ok {
+    return ErrNodeCategory
+  }
+  return nil
+}
+
+// Check that every value is declared with a default of the same type
+func checkDeclared(node ProjectNode, values map[string]interface{}, declared map[string]interface{}) error {
+  keys := make([]string, 0, len(values))
+  for key := range values {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  var errs ErrorList
+  for _, key := range keys {
+    value := values[key]
+    def, ok := declared[key]
+    if !ok {
+      errs = errs.add(node, &PropertyError{key, value, ErrUnknownProperty})
+    } else if !sameJSONType(value, def) {
+      errs = errs.add(node, &PropertyError{key, value, ErrPropertyType})
+    }
+  }
+  return errs.err()
+}
+
+// Check if two decoded JSON values have the same type
+// A null default accepts anything
+func sameJSONType(a, b interface{}) bool {
+  switch b.(type) {
+  case nil:
+    return true
+  case float64:
+    _, ok := a.(float64)
+    return ok
+  case string:
+    _, ok := a.(string)
+    return ok
+  case bool:
+    _, ok := a.(bool)
+    return ok
+  case []interface{}:
+    _, ok := a.([]interface{})
+    return ok
+  case map[string]interface{}:
+    _, ok := a.(map[string]interface{})
+    return ok
+  default:
+    return true
+  }
+}
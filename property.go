@@ -0,0 +1,363 @@
+package behaviortree
+
+import (
+  "fmt"
+  "time"
+  "errors"
+  "reflect"
+  "strconv"
+  "strings"
+)
+
+var (
+  // A required property is not set
+  ErrMissingProperty = errors.New("missing property")
+  // A property has a type that cannot be converted
+  ErrPropertyType = errors.New("wrong property type")
+  // A property has a value outside of what is allowed
+  ErrPropertyValue = errors.New("invalid property value")
+)
+
+// A problem with a single property of a node
+type PropertyError struct {
+  Key string
+  Value interface{}
+  Err error
+}
+
+func (e *PropertyError) Error() string {
+  if e.Value == nil {
+    return fmt.Sprintf("property %q: %s", e.Key, e.Err)
+  }
+  return fmt.Sprintf("property %q: %s: %#v", e.Key, e.Err, e.Value)
+}
+
+func (e *PropertyError) Unwrap() error {
+  return e.Err
+}
+
+// Check if the property is set
+func (n ProjectNode) Has(key string) bool {
+  _, ok := n.Properties[key]
+  return ok
+}
+
+// Get a numeric property
+// Numbers written as strings are accepted as well
+func (n ProjectNode) Float(key string, def float64) (float64, error) {
+  v, ok := n.Properties[key]
+  if !ok {
+    return def, nil
+  }
+  switch val := v.(type) {
+  case float64:
+    return val, nil
+  case int:
+    return float64(val), nil
+  case string:
+    f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+    if err != nil {
+      return def, &PropertyError{key, v, ErrPropertyType}
+    }
+    return f, nil
+  default:
+    return def, &PropertyError{key, v, ErrPropertyType}
+  }
+}
+
+// Get an integer property
+// Fractional numbers are rejected
+func (n ProjectNode) Int(key string, def int) (int, error) {
+  f, err := n.Float(key, float64(def))
+  if err != nil {
+    return def, err
+  }
+  if f != float64(int(f)) {
+    return def, &PropertyError{key, n.Properties[key], ErrPropertyValue}
+  }
+  return int(f), nil
+}
+
+// Get a duration property
+// Numbers are milliseconds, like the behavior3 ms properties,
+// strings may also use time.ParseDuration syntax
+func (n ProjectNode) Duration(key string, def time.Duration) (time.Duration, error) {
+  if s, ok := n.Properties[key].(string); ok {
+    if d, err := time.ParseDuration(strings.TrimSpace(s)); err == nil {
+      return d, nil
+    }
+  }
+  f, err := n.Float(key, float64(def)/float64(time.Millisecond))
+  if err != nil {
+    return def, err
+  }
+  return time.Duration(f*float64(time.Millisecond)), nil
+}
+
+// Get a list of numbers
+// Either an array or a string of comma separated numbers
+func (n ProjectNode) Floats(key string, def []float64) ([]float64, error) {
+  v, ok := n.Properties[key]
+  if !ok {
+    return def, nil
+  }
+  var items []interface{}
+  switch val := v.(type) {
+  case []float64:
+    return val, nil
+  case []interface{}:
+    items = val
+  case string:
+    for _, item := range strings.Split(val, ",") {
+      items = append(items, item)
+    }
+  default:
+    return def, &PropertyError{key, v, ErrPropertyType}
+  }
+  floats := make([]float64, len(items))
+  for idx, item := range items {
+    f, err := ProjectNode{Properties: map[string]interface{}{key: item}}.Float(key, 0)
+    if err != nil {
+      return def, &PropertyError{key, v, ErrPropertyType}
+    }
+    floats[idx] = f
+  }
+  return floats, nil
+}
+
+// Get a list of strings
+// Either an array or a comma separated string, spaces are trimmed
+func (n ProjectNode) Strings(key string, def []string) ([]string, error) {
+  v, ok := n.Properties[key]
+  if !ok {
+    return def, nil
+  }
+  switch val := v.(type) {
+  case []string:
+    return val, nil
+  case []interface{}:
+    strs := make([]string, len(val))
+    for idx, item := range val {
+      s, ok := item.(string)
+      if !ok {
+        return def, &PropertyError{key, v, ErrPropertyType}
+      }
+      strs[idx] = s
+    }
+    return strs, nil
+  case string:
+    strs := strings.Split(val, ",")
+    for idx := range strs {
+      strs[idx] = strings.TrimSpace(strs[idx])
+    }
+    return strs, nil
+  default:
+    return def, &PropertyError{key, v, ErrPropertyType}
+  }
+}
+
+// Get a string property
+func (n ProjectNode) String(key string, def string) (string, error) {
+  v, ok := n.Properties[key]
+  if !ok {
+    return def, nil
+  }
+  s, ok := v.(string)
+  if !ok {
+    return def, &PropertyError{key, v, ErrPropertyType}
+  }
+  return s, nil
+}
+
+// Get a boolean property
+// The strings "true" and "false" are accepted as well
+func (n ProjectNode) Bool(key string, def bool) (bool, error) {
+  v, ok := n.Properties[key]
+  if !ok {
+    return def, nil
+  }
+  switch val := v.(type) {
+  case bool:
+    return val, nil
+  case string:
+    b, err := strconv.ParseBool(strings.TrimSpace(val))
+    if err != nil {
+      return def, &PropertyError{key, v, ErrPropertyType}
+    }
+    return b, nil
+  default:
+    return def, &PropertyError{key, v, ErrPropertyType}
+  }
+}
+
+// Get a string property that must be one of allowed
+func (n ProjectNode) Enum(key string, def string, allowed ...string) (string, error) {
+  s, err := n.String(key, def)
+  if err != nil {
+    return def, err
+  }
+  for _, a := range allowed {
+    if s == a {
+      return s, nil
+    }
+  }
+  return def, &PropertyError{key, s, ErrPropertyValue}
+}
+
+// Get a status property
+// Either a status name in any case or its numeric value
+func (n ProjectNode) Status(key string, def Status) (Status, error) {
+  v, ok := n.Properties[key]
+  if !ok {
+    return def, nil
+  }
+  if s, ok := v.(string); ok {
+    if status, ok := ParseStatus(s); ok {
+      return status, nil
+    }
+  }
+  i, err := n.Int(key, int(def))
+  if err != nil || Status(i).String() == "Invalid" {
+    return def, &PropertyError{key, v, ErrPropertyValue}
+  }
+  return Status(i), nil
+}
+
+// Parse a status name like "Success" or "RUNNING"
+func ParseStatus(s string) (Status, bool) {
+  for status := Failure; status.String() != "Invalid"; status++ {
+    if strings.EqualFold(strings.TrimSpace(s), status.String()) {
+      return status, true
+    }
+  }
+  return Failure, false
+}
+
+var (
+  durationType = reflect.TypeOf(time.Duration(0))
+  statusType = reflect.TypeOf(Status(0))
+)
+
+// Decode the properties into the struct pointed to by v
+// Fields are matched using the b3 tag, like
+//   Limit int `b3:"limit"`
+//   Mode string `b3:"mode,required,enum=a|b"`
+// Fields without a tag or a missing property keep their value,
+// so defaults can be set before decoding.
+// All problems are returned together as an ErrorList
+func (n ProjectNode) Decode(v interface{}) error {
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+    return fmt.Errorf("decode into %T: not a struct pointer", v)
+  }
+  rv = rv.Elem()
+  var errs ErrorList
+  for i := 0; i < rv.NumField(); i++ {
+    field := rv.Type().Field(i)
+    tag, ok := field.Tag.Lookup("b3")
+    if !ok || tag == "-" {
+      continue
+    }
+    opts := strings.Split(tag, ",")
+    key := opts[0]
+    if key == "" {
+      key = field.Name
+    }
+    var allowed []string
+    required := false
+    for _, opt := range opts[1:] {
+      if opt == "required" {
+        required = true
+      } else if strings.HasPrefix(opt, "enum=") {
+        allowed = strings.Split(strings.TrimPrefix(opt, "enum="), "|")
+      }
+    }
+    if !n.Has(key) {
+      if required {
+        errs = errs.add(n, &PropertyError{Key: key, Err: ErrMissingProperty})
+      }
+      continue
+    }
+    errs = errs.add(n, n.decodeField(key, rv.Field(i), allowed))
+  }
+  return errs.err()
+}
+
+// Decode a single property into a struct field
+func (n ProjectNode) decodeField(key string, fv reflect.Value, allowed []string) error {
+  switch {
+  case fv.Type() == durationType:
+    d, err := n.Duration(key, 0)
+    if err == nil {
+      fv.SetInt(int64(d))
+    }
+    return err
+  case fv.Type() == statusType:
+    s, err := n.Status(key, 0)
+    if err == nil {
+      fv.SetInt(int64(s))
+    }
+    return err
+  }
+  switch fv.Kind() {
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    i, err := n.Int(key, 0)
+    if err == nil {
+      if fv.OverflowInt(int64(i)) {
+        return &PropertyError{key, n.Properties[key], ErrPropertyValue}
+      }
+      fv.SetInt(int64(i))
+    }
+    return err
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    i, err := n.Int(key, 0)
+    if err == nil {
+      if i < 0 || fv.OverflowUint(uint64(i)) {
+        return &PropertyError{key, n.Properties[key], ErrPropertyValue}
+      }
+      fv.SetUint(uint64(i))
+    }
+    return err
+  case reflect.Float32, reflect.Float64:
+    f, err := n.Float(key, 0)
+    if err == nil {
+      fv.SetFloat(f)
+    }
+    return err
+  case reflect.Bool:
+    b, err := n.Bool(key, false)
+    if err == nil {
+      fv.SetBool(b)
+    }
+    return err
+  case reflect.Slice:
+    var v interface{}
+    var err error
+    switch fv.Type().Elem().Kind() {
+    case reflect.Float64:
+      v, err = n.Floats(key, nil)
+    case reflect.String:
+      v, err = n.Strings(key, nil)
+    default:
+      return fmt.Errorf("property %q: unsupported field type %s", key, fv.Type())
+    }
+    if err == nil {
+      fv.Set(reflect.ValueOf(v).Convert(fv.Type()))
+    }
+    return err
+  case reflect.String:
+    var s string
+    var err error
+    if allowed != nil {
+      s, err = n.Enum(key, "", allowed...)
+    } else {
+      s, err = n.String(key, "")
+    }
+    if err == nil {
+      fv.SetString(s)
+    }
+    return err
+  default:
+    return fmt.Errorf("property %q: unsupported field type %s", key, fv.Type())
+  }
+}
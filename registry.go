@@ -0,0 +1,452 @@
+package behaviortree
+
+import (
+  "sort"
+  "sync"
+  "time"
+)
+
+// A set of node constructors, looked up by behavior3 node name
+// It is safe to use from multiple goroutines
+type Registry struct {
+  mu sync.RWMutex
+  constructors map[string]NodeConstructor
+  scores map[string]ScoreFunc
+}
+
+// The registry used by MakeTrees and MakeNode
+var globalRegistry = DefaultRegistry()
+
+// Create a new registry without any nodes
+func NewRegistry() *Registry {
+  r := new(Registry)
+  r.constructors = make(map[string]NodeConstructor)
+  r.scores = make(map[string]ScoreFunc)
+  return r
+}
+
+// Create a new registry with the built-in behavior3 nodes
+// like Priority, MemPriority, Sequence, ParallelSequence, Repeat and Sleep
+func DefaultRegistry() *Registry {
+  r := NewRegistry()
+  registerBuiltins(r)
+  return r
+}
+
+// Register a constructor for nodes with the given name
+// in the registry used by MakeTrees and MakeNode
+func Register(name string, fn NodeConstructor) {
+  globalRegistry.Register(name, fn)
+}
+
+// Register a constructor for nodes with the given name
+// An existing constructor with that name is replaced
+func (r *Registry) Register(name string, fn NodeConstructor) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.constructors[name] = fn
+}
+
+// Register a score function for UtilitySelector nodes
+// in the registry used by MakeTrees and MakeNode
+func RegisterScore(name string, fn ScoreFunc) {
+  globalRegistry.RegisterScore(name, fn)
+}
+
+// Register a score function for UtilitySelector nodes
+// An existing function with that name is replaced
+func (r *Registry) RegisterScore(name string, fn ScoreFunc) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.scores[name] = fn
+}
+
+// Find the score function with the given name
+func (r *Registry) LookupScore(name string) (ScoreFunc, bool) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+  fn, ok := r.scores[name]
+  return fn, ok
+}
+
+// Find the constructor for nodes with the given name
+func (r *Registry) Lookup(name string) (NodeConstructor, bool) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+  fn, ok := r.constructors[name]
+  return fn, ok
+}
+
+// The sorted names of all registered nodes
+func (r *Registry) Names() []string {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+  names := make([]string, 0, len(r.constructors))
+  for name := range r.constructors {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// Create an independent copy of the registry
+func (r *Registry) Clone() *Registry {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+  c := NewRegistry()
+  for name, fn := range r.constructors {
+    c.constructors[name] = fn
+  }
+  for name, fn := range r.scores {
+    c.scores[name] = fn
+  }
+  return c
+}
+
+// Like MakeTrees, but using the nodes in this registry
+// Subtrees get a fresh copy for every reference,
+// use a Builder to share them instead
+func (r *Registry) MakeTrees(pr *Project, trees map[string]Node) error {
+  b := &Builder{Registry: r, Project: pr}
+  return b.MakeTrees(trees)
+}
+
+// Like MakeNode, but using the nodes in this registry
+func (r *Registry) MakeNode(root string, nodes map[string]ProjectNode) (Node, error) {
+  b := &Builder{Registry: r, Nodes: nodes}
+  return b.MakeNode(root)
+}
+
+// Properties of the ParallelSequence and ParallelTactic nodes
+type parallelProperties struct {
+  MinSuccess int `b3:"minSuccess"`
+  MinFail int `b3:"minFail"`
+}
+
+// Properties of the WeightedSelector node
+type weightedProperties struct {
+  Weights []float64 `b3:"weights,required"`
+}
+
+// Properties of the UtilitySelector node
+type utilityProperties struct {
+  Scores []string `b3:"scores"`
+  Hysteresis float64 `b3:"hysteresis"`
+  FallThrough bool `b3:"fallThrough"`
+}
+
+// Properties of the Sleep node
+type sleepProperties struct {
+  Ms time.Duration `b3:"ms,required"`
+}
+
+// Properties of the Timeout node
+type timeoutProperties struct {
+  Ms time.Duration `b3:"ms,required"`
+  Completion Status `b3:"completion"`
+}
+
+// Properties of the Retry node
+type retryProperties struct {
+  MaxAttempts int `b3:"maxAttempts"`
+  Backoff time.Duration `b3:"backoff"`
+  MaxBackoff time.Duration `b3:"maxBackoff"`
+  Multiplier float64 `b3:"multiplier"`
+  Jitter float64 `b3:"jitter"`
+}
+
+// Properties of the Cooldown node
+type cooldownProperties struct {
+  Cooldown time.Duration `b3:"cooldown,required"`
+  Status Status `b3:"status"`
+}
+
+// Properties of the RateLimit node
+type rateLimitProperties struct {
+  Limit int `b3:"limit,required"`
+  Window time.Duration `b3:"window,required"`
+  Status Status `b3:"status"`
+}
+
+// Properties of the MaxTime node
+type maxTimeProperties struct {
+  MaxTime time.Duration `b3:"maxTime,required"`
+}
+
+// Properties of the MaxTicks node
+type maxTicksProperties struct {
+  MaxTicks int `b3:"maxTicks,required"`
+}
+
+// Register the behavior3 nodes that ship with this package
+func registerBuiltins(r *Registry) {
+  // Composite nodes
+  r.Register("Priority", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewSelectorNode(children), nil
+  })
+
+  r.Register("MemPriority", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewSelectorMemoryNode(children), nil
+  })
+
+  r.Register("Sequence", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewSequentialNode(children), nil
+  })
+
+  r.Register("MemSequence", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewSequentialMemoryNode(children), nil
+  })
+
+  r.Register("ReactiveSequence", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewReactiveSequenceNode(children), nil
+  })
+
+  r.Register("ReactiveFallback", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewReactiveFallbackNode(children), nil
+  })
+
+  r.Register("RandomSelector", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewRandomSelectorNode(children), nil
+  })
+
+  r.Register("RandomSequence", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewRandomSequenceNode(children), nil
+  })
+
+  r.Register("WeightedSelector", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    var props weightedProperties
+    errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props))
+    if len(errs) == 0 && len(props.Weights) != len(children) {
+      errs = errs.add(root, &PropertyError{"weights", root.Properties["weights"], ErrPropertyValue})
+    }
+    if len(errs) > 0 {
+      return nil, errs
+    }
+    return NewWeightedSelectorNode(props.Weights, children), nil
+  })
+
+  r.Register("UtilitySelector", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    var props utilityProperties
+    errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props))
+    if len(errs) == 0 && len(props.Scores) > len(children) {
+      errs = errs.add(root, &PropertyError{"scores", root.Properties["scores"], ErrPropertyValue})
+    }
+    scores := make([]ScoreFunc, len(props.Scores))
+    for idx, name := range props.Scores {
+      if name == "" {
+        continue
+      }
+      if fn, ok := b.Registry.LookupScore(name); ok {
+        scores[idx] = fn
+      } else {
+        errs = errs.add(root, &PropertyError{"scores", name, ErrPropertyValue})
+      }
+    }
+    if len(errs) > 0 {
+      return nil, errs
+    }
+    n := NewUtilitySelectorNode(scores, children)
+    n.ScoreNames = props.Scores
+    n.Hysteresis = props.Hysteresis
+    n.FallThrough = props.FallThrough
+    return n, nil
+  })
+
+  r.Register("ParallelSequence", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    var props parallelProperties
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    if root.Has("minSuccess") && root.Has("minFail") {
+      return NewParallelNodeBounded(props.MinSuccess, props.MinFail, children), nil
+    } else {
+      return NewParallelNodeAll(true, false, children), nil
+    }
+  })
+
+  r.Register("ParallelTactic", func(b *Builder, root ProjectNode) (Node, error) {
+    children, err := b.MakeChildren(root)
+    var props parallelProperties
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    if root.Has("minSuccess") && root.Has("minFail") {
+      return NewParallelMemoryNodeBounded(props.MinSuccess, props.MinFail, children), nil
+    } else {
+      return NewParallelMemoryNodeAll(true, false, children), nil
+    }
+  })
+
+  // Decorator nodes
+  r.Register("Inverter", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewInverterNode(child), nil
+  })
+
+  r.Register("FailerDec", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewWrapConstantNode(Failure, child), nil
+  })
+
+  r.Register("SucceederDec", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewWrapConstantNode(Success, child), nil
+  })
+
+  r.Register("Repeat", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    limit, perr := root.Int("limit", -1)
+    if errs := ErrorList(nil).add(root, err).add(root, perr); len(errs) > 0 {
+      return nil, errs
+    }
+    return NewRepeaterNode(limit, child), nil
+  })
+
+  r.Register("RepeatUntilSuccess", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewRepeatUntilNode(Success, child), nil
+  })
+
+  r.Register("RepeatUntilFailure", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    if err != nil {
+      return nil, err
+    }
+    return NewRepeatUntilNode(Failure, child), nil
+  })
+
+  r.Register("Timeout", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    var props timeoutProperties
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    return NewTimeoutNode(props.Ms, props.Completion, child), nil
+  })
+
+  r.Register("Retry", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    props := retryProperties{Multiplier: 2}
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    n := NewRetryNode(props.MaxAttempts, props.Backoff, child)
+    n.MaxBackoff = props.MaxBackoff
+    n.Multiplier = props.Multiplier
+    n.Jitter = props.Jitter
+    return n, nil
+  })
+
+  r.Register("Cooldown", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    var props cooldownProperties
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    n := NewCooldownNode(props.Cooldown, child)
+    n.Cooling = props.Status
+    return n, nil
+  })
+
+  r.Register("RateLimit", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    var props rateLimitProperties
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    n := NewRateLimitNode(props.Limit, props.Window, child)
+    n.Limited = props.Status
+    return n, nil
+  })
+
+  r.Register("MaxTime", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    var props maxTimeProperties
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    return NewMaxTimeNode(props.MaxTime, child), nil
+  })
+
+  r.Register("MaxTicks", func(b *Builder, root ProjectNode) (Node, error) {
+    child, err := b.MakeChild(root)
+    var props maxTicksProperties
+    if errs := ErrorList(nil).add(root, err).add(root, root.Decode(&props)); len(errs) > 0 {
+      return nil, errs
+    }
+    return NewMaxTicksNode(props.MaxTicks, child), nil
+  })
+
+  // Utility nodes
+  r.Register("Failer", func(b *Builder, root ProjectNode) (Node, error) {
+    return NewConstantNode(Failure), nil
+  })
+
+  r.Register("Succeeder", func(b *Builder, root ProjectNode) (Node, error) {
+    return NewConstantNode(Success), nil
+  })
+
+  r.Register("Runner", func(b *Builder, root ProjectNode) (Node, error) {
+    return NewConstantNode(Running), nil
+  })
+
+  r.Register("Error", func(b *Builder, root ProjectNode) (Node, error) {
+    return NewConstantNode(Error), nil
+  })
+
+  r.Register("Sleep", func(b *Builder, root ProjectNode) (Node, error) {
+    var props sleepProperties
+    if err := root.Decode(&props); err != nil {
+      return nil, err
+    }
+    return NewTimeoutNode(props.Ms, Success, NewConstantNode(Running)), nil
+  })
+}
+
@@ -2,8 +2,12 @@ package behaviortree
 
 import (
   "testing"
+  "fmt"
   "log"
   "io/ioutil"
+  "context"
+  "errors"
+  "time"
 )
 
 // Disable logging
@@ -135,3 +139,267 @@ func TestFizzBuzz(t *testing.T) {
     []Status{Failure,Failure,Success,Failure,Success,Success,Failure,Failure,Success,Success,Failure,Success,Failure,Failure,Success},
   )
 }
+
+// A goroutine leaf that adds its tick count and the state as a message
+// and succeeds after limit ticks
+func NewCountingGoroutineNode(t *testing.T, limit int) *GoroutineLeafNode {
+  return NewGoroutineMessageLeafNode(func(ticks <-chan GoroutineTick, results chan<- GoroutineResult) {
+    i := 0
+    for tick := range ticks {
+      i++
+      t.Logf("go %d", i)
+      status := Running
+      if i == limit {
+        status = Success
+      }
+      results <- GoroutineResult{status, append(tick.Messages, fmt.Sprintf("%d/%v", i, tick.State))}
+    }
+  })
+}
+
+func TestGoroutineMessage(t *testing.T) {
+  n := NewSequentialNode([]Node{
+    NewMessageNode(t, "Hello"),
+    NewCountingGoroutineNode(t, 2),
+  })
+  expectMessageSequence(t, n,
+    []interface{}{1,2,3},
+    [][]interface{}{{"Hello", "1/1"}, {"Hello", "2/2"}, {"Hello", "1/3"}},
+    []Status{Running,Success,Running},
+  )
+
+  p := NewSequentialNode([]Node{
+    NewParallelNodeAll(true, false, []Node{
+      NewCountingGoroutineNode(t, 1),
+      NewCountingGoroutineNode(t, 2),
+    }),
+    NewConcatNode(t),
+  })
+  expectMessageSequence(t, p,
+    []interface{}{1,2},
+    [][]interface{}{{"1/1", "1/1"}, {"1/22/2"}},
+    []Status{Running,Success},
+  )
+}
+
+func TestTypedFizzBuzz(t *testing.T) {
+  modulus := func(mod int) TypedNode[int, string] {
+    return Typed[int, string](NewPredicateLeafNode(func(state int) bool {
+      return state % mod == 0
+    }))
+  }
+  message := func(msg string) TypedNode[int, string] {
+    return NewTypedLeafNode(func(state int, messages []string) (Status, []string) {
+      return Success, append(messages, msg)
+    })
+  }
+  concat := NewTypedLeafNode(func(state int, messages []string) (Status, []string) {
+    s := ""
+    for _, m := range messages {
+      s += m
+    }
+    return Success, []string{s}
+  })
+  parallel := func(children []Node) *ParallelNode {
+    return NewParallelNodeAll(false, true, children)
+  }
+  n := Compose(NewSequentialNode,
+    Compose(parallel,
+      Compose(NewSequentialNode, modulus(3), message("Fizz")),
+      Compose(NewSequentialNode, modulus(5), message("Buzz")),
+    ),
+    concat,
+  )
+  expected := []string{"", "", "Fizz", "", "Buzz", "Fizz", "", "", "Fizz", "Buzz", "", "Fizz", "", "", "FizzBuzz"}
+  for idx, msg := range expected {
+    status, messages := TickTyped(n, idx+1, nil)
+    if msg == "" && status != Failure || msg != "" && (status != Success || len(messages) != 1 || messages[0] != msg) {
+      t.Errorf("Unexpected %s %v at %d", status, messages, idx+1)
+    }
+  }
+
+  if status, _ := TickTyped(Decorate(NewInverterNode, concat), 1, []string{"a"}); status != Failure {
+    t.Errorf("Status is %s", status)
+  }
+  if status, _ := Tick(concat.Node, "state", nil); status != Error {
+    t.Errorf("Wrong state type not reported: %s", status)
+  }
+
+  clock := NewManualClock(time.Unix(0, 0))
+  timeout := Decorate(func(child Node) *TimeoutNode {
+    return NewTimeoutNode(time.Hour, Failure, child)
+  }, Typed[int, string](NewConstantNode(Running)))
+  expectClockSequence(t, &Agent{Clock: clock}, clock, NewSequentialNode([]Node{timeout.Node}), []clockStep{{0, Running}, {2*time.Hour, Failure}})
+  untyped := Typed[int, string](NewMessageNode(t, 42))
+  a := new(Agent)
+  if status, messages := untyped.TickAgent(a, 1, nil); status != Error || len(messages) != 0 {
+    t.Errorf("Wrong message type not reported: %s %v", status, messages)
+  }
+  var err *TickError
+  if !errors.As(a.Err(), &err) || !errors.Is(err, ErrMessageType) || err.Node() != untyped.Node {
+    t.Errorf("Unexpected error %v", a.Err())
+  }
+  a = &Agent{Panics: Repanic}
+  if status, _ := a.Tick(concat.Node, 1, []interface{}{42}); status != Error || !errors.As(a.Err(), &err) || !errors.Is(err, ErrMessageType) || err.Node() != concat.Node || err.Stack != nil {
+    t.Errorf("Wrong leaf message type not reported: %s %v", status, a.Err())
+  }
+}
+
+// A leaf that counts its ticks in the blackboard
+// Returns Running on odd and Success on even counts
+type CounterNode struct {
+  BasicNode
+}
+
+func (n *CounterNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  m := a.Memory(n)
+  count := m.Int("count", 0) + 1
+  m.Set("count", count)
+  global := a.Blackboard.Global()
+  global.Set("total", global.Int("total", 0) + 1)
+  if count % 2 == 0 {
+    return Success, messages
+  }
+  return Running, messages
+}
+
+func TestBlackboard(t *testing.T) {
+  counter := new(CounterNode)
+  seq := NewSequentialMemoryNode([]Node{counter, NewConstantNode(Success)})
+  rep := NewRepeaterNode(2, seq)
+  a := &Agent{Blackboard: NewBlackboard(), Tree: rep}
+  b := &Agent{Blackboard: NewBlackboard(), Tree: rep}
+
+  expected := []Status{Running, Running, Running, Success}
+  for idx, exp := range expected {
+    if status, _ := a.Tick(rep, nil, nil); status != exp {
+      t.Errorf("Status is %s, expected %s at index %d", status, exp, idx)
+    }
+    if idx == 1 {
+      if status, _ := b.Tick(rep, nil, nil); status != Running {
+        t.Errorf("Status of second agent is %s", status)
+      }
+    }
+  }
+  if count := a.Blackboard.Node(rep, counter).Int("count", 0); count != 4 {
+    t.Errorf("First agent counted %d", count)
+  }
+  if count := b.Blackboard.Node(rep, counter).Int("count", 0); count != 1 {
+    t.Errorf("Second agent counted %d", count)
+  }
+  if total := a.Blackboard.Global().Int("total", 0); total != 4 {
+    t.Errorf("Global total is %d", total)
+  }
+  if a.Status(rep) != Success || b.Status(rep) != Running {
+    t.Errorf("Statuses are %s and %s", a.Status(rep), b.Status(rep))
+  }
+  if rep.Counter != 0 || rep.Status != Failure || seq.CurrentIndex != 0 {
+    t.Errorf("Memory was kept in the nodes")
+  }
+}
+
+type contextKey string
+
+// A running leaf that reports the context value "name"
+// and can cancel the context it is ticked with
+type ContextLeafNode struct {
+  BasicNode
+  cancel func()
+  done bool
+  Ticks int
+  Terminations int
+}
+
+func (n *ContextLeafNode) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  n.Ticks++
+  if n.cancel != nil {
+    n.cancel()
+  }
+  if n.done {
+    return Success, messages
+  }
+  return Running, append(messages, a.Ctx().Value(contextKey("name")))
+}
+
+func (n *ContextLeafNode) Terminate() {
+  n.Terminations++
+}
+
+// A running leaf that panics when terminated
+type PanicTerminateNode struct {
+  ContextLeafNode
+}
+
+func (n *PanicTerminateNode) Terminate() {
+  panic("terminate")
+}
+
+func TestTickContext(t *testing.T) {
+  ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey("name"), "ctx"))
+  first := new(ContextLeafNode)
+  second := &ContextLeafNode{cancel: cancel}
+  third := new(ContextLeafNode)
+  n := NewParallelNodeAll(true, true, []Node{first, second, third})
+
+  a := &Agent{Context: ctx}
+  status, messages := a.Tick(n, nil, nil)
+  if status != Error || !errors.Is(a.Err(), context.Canceled) {
+    t.Errorf("Status is %s, error %v", status, a.Err())
+  }
+  if len(messages) != 2 || messages[0] != "ctx" {
+    t.Errorf("Unexpected messages %v", messages)
+  }
+  if third.Ticks != 0 {
+    t.Errorf("Child ticked after cancellation")
+  }
+  if first.Terminations != 1 || second.Terminations != 1 || third.Terminations != 0 {
+    t.Errorf("Running children not halted: %d %d %d", first.Terminations, second.Terminations, third.Terminations)
+  }
+  if first.Status == Running || second.Status == Running {
+    t.Errorf("Halted children still running")
+  }
+
+  status, _ = TickContext(ctx, n, nil, nil)
+  if status != Error || first.Ticks != 1 {
+    t.Errorf("Ticked with a done context")
+  }
+
+  leaf := new(ContextLeafNode)
+  status, messages = Tick(leaf, nil, nil)
+  if status != Running || len(messages) != 1 || messages[0] != nil {
+    t.Errorf("Ticked without a context to %s, %v", status, messages)
+  }
+
+  ctx, cancel = context.WithCancel(context.Background())
+  a = &Agent{Context: ctx}
+  panicking := new(PanicTerminateNode)
+  a.Tick(panicking, nil, nil)
+  cancel()
+  var err *TickError
+  if status, _ := a.Tick(panicking, nil, nil); status != Error || !errors.As(a.Err(), &err) || err.Value != "terminate" {
+    t.Errorf("Halt that panics ended in %s, error %v", status, a.Err())
+  }
+
+  cancelled := func() (*Agent, Node) {
+    ctx, cancel := context.WithCancel(context.Background())
+    return &Agent{Context: ctx}, NewSequentialNode([]Node{&ContextLeafNode{cancel: cancel, done: true}, NewConstantNode(Running)})
+  }
+  a, child := cancelled()
+  if status, _ := a.Tick(NewInverterNode(child), nil, nil); status != Error {
+    t.Errorf("Inverter turned cancellation into %s", status)
+  }
+  a, child = cancelled()
+  if status, _ := a.Tick(NewRepeatUntilNode(Failure, child), nil, nil); status != Error {
+    t.Errorf("RepeatUntil turned cancellation into %s", status)
+  }
+  a, child = cancelled()
+  repeater := NewRepeaterNode(3, child)
+  if status, _ := a.Tick(repeater, nil, nil); status != Error || repeater.Counter != 0 {
+    t.Errorf("Repeater turned cancellation into %s after %d", status, repeater.Counter)
+  }
+  a, child = cancelled()
+  retry := NewRetryNode(3, time.Second, child)
+  if status, _ := a.Tick(retry, nil, nil); status != Error || retry.Attempts != 0 {
+    t.Errorf("Retry turned cancellation into %s after %d attempts", status, retry.Attempts)
+  }
+}
@@ -0,0 +1,117 @@
+package behaviortree
+
+import (
+  "io"
+  "fmt"
+)
+
+// The moments of a tick a Tracer is told about
+type TraceKind int
+
+const (
+  // The node starts running
+  TraceInitiate TraceKind = iota
+  // The node is about to be updated
+  TraceBeforeUpdate
+  // The node was updated
+  TraceAfterUpdate
+  // The node stopped running, or was halted
+  TraceTerminate
+  // The child of a RetryNode failed
+  TraceRetry
+)
+
+func (k TraceKind) String() string {
+  switch k {
+  case TraceInitiate:
+    return "Initiate"
+  case TraceBeforeUpdate:
+    return "BeforeUpdate"
+  case TraceAfterUpdate:
+    return "AfterUpdate"
+  case TraceTerminate:
+    return "Terminate"
+  case TraceRetry:
+    return "Retry"
+  default:
+    return "Invalid"
+  }
+}
+
+// Something that happened to a node during a tick
+type TraceEvent struct {
+  Kind TraceKind
+  // Counts the ticks of a root by the agent, starting at 1
+  Tick int
+  Node Node
+  // The nodes from the ticked root down to Node
+  // Only valid during the call to Trace
+  Path []Node
+  // The status before this tick
+  Previous Status
+  // The status after the update, or Previous before it
+  Status Status
+  Messages []interface{}
+  // The failed attempts so far, for TraceRetry
+  Attempt int
+}
+
+// Observes the nodes an Agent ticks
+type Tracer interface {
+  Trace(e *TraceEvent)
+}
+
+// Tell the tracer about node, if there is one
+func (a *Agent) trace(kind TraceKind, node Node, previous Status, status Status, messages []interface{}) {
+  if a.Tracer == nil {
+    return
+  }
+  a.Tracer.Trace(&TraceEvent{
+    Kind: kind,
+    Tick: a.ticks,
+    Node: node,
+    Path: a.path,
+    Previous: previous,
+    Status: status,
+    Messages: messages,
+  })
+}
+
+// Tell the tracer that attempt of node failed
+func (a *Agent) traceRetry(node Node, attempt int, messages []interface{}) {
+  if a.Tracer == nil {
+    return
+  }
+  status := a.Status(node)
+  a.Tracer.Trace(&TraceEvent{
+    Kind: TraceRetry,
+    Tick: a.ticks,
+    Node: node,
+    Path: a.path,
+    Previous: status,
+    Status: status,
+    Messages: messages,
+    Attempt: attempt,
+  })
+}
+
+// A Tracer that writes one line per event,
+// with key=value pairs for the tick, event, path and statuses,
+// and the attempt of retries
+// The path names each node by type, index in its parent and id
+type LogTracer struct {
+  W io.Writer
+}
+
+func NewLogTracer(w io.Writer) *LogTracer {
+  return &LogTracer{W: w}
+}
+
+func (t *LogTracer) Trace(e *TraceEvent) {
+  attempt := ""
+  if e.Kind == TraceRetry {
+    attempt = fmt.Sprintf(" attempt=%d", e.Attempt)
+  }
+  fmt.Fprintf(t.W, "tick=%d event=%s path=%s previous=%s status=%s messages=%d%s\n",
+    e.Tick, e.Kind, pathString(e.Path, "/"), e.Previous, e.Status, len(e.Messages), attempt)
+}
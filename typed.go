@@ -0,0 +1,133 @@
+package behaviortree
+
+import (
+  "fmt"
+  "errors"
+)
+
+// A message did not have the type of the typed tree it went through
+var ErrMessageType = errors.New("message of unexpected type")
+
+// A node whose state and messages have the types S and M
+// The types are only known to the compiler,
+// the node itself is an ordinary Node, so typed and untyped
+// nodes can be mixed with Typed and the Node field
+// A TypedNode is not a Node itself, use the Node field
+// to put it in an untyped tree or tick it without types
+type TypedNode[S, M any] struct {
+  Node Node
+}
+
+// Use an untyped node in a typed tree
+// Its messages are checked when they reach a typed node
+func Typed[S, M any](node Node) TypedNode[S, M] {
+  return TypedNode[S, M]{node}
+}
+
+// Like Tick, with typed state and messages
+func TickTyped[S, M any](node TypedNode[S, M], state S, messages []M) (Status, []M) {
+  return node.TickAgent(new(Agent), state, messages)
+}
+
+// Tick the node on behalf of an agent
+// Messages of another type than M make the tick end in Error,
+// recorded in the agent at the node, and are left out
+func (n TypedNode[S, M]) TickAgent(a *Agent, state S, messages []M) (Status, []M) {
+  status, out := a.Tick(n.Node, state, untypedMessages(messages))
+  typed, err := typedMessages[M](out)
+  if err != nil {
+    a.path = append(a.path, n.Node)
+    a.fail(err, nil)
+    a.path = a.path[:len(a.path)-1]
+    return Error, typed
+  }
+  return status, typed
+}
+
+// Make a composite node with typed children
+// constructor is a function like NewSequentialNode
+func Compose[S, M any, N Node](constructor func(children []Node) N, children ...TypedNode[S, M]) TypedNode[S, M] {
+  nodes := make([]Node, len(children))
+  for idx, child := range children {
+    nodes[idx] = child.Node
+  }
+  return TypedNode[S, M]{constructor(nodes)}
+}
+
+// Make a decorator node with a typed child
+// constructor is a function like NewInverterNode
+func Decorate[S, M any, N Node](constructor func(child Node) N, child TypedNode[S, M]) TypedNode[S, M] {
+  return TypedNode[S, M]{constructor(child.Node)}
+}
+
+// A leaf node that runs an update function with typed state and messages
+// The function returns the status and the new messages
+type TypedLeafNode[S, M any] struct {
+  BasicNode
+  update func(state S, messages []M) (Status, []M)
+}
+
+func (n *TypedLeafNode[S, M]) Update(state interface{}, messages []interface{}) []interface{} {
+  n.Status, messages = n.UpdateAgent(new(Agent), state, messages)
+  return messages
+}
+
+// Messages of another type than M end in Error, recorded in the agent
+// Panics when state has the wrong type, which the agent turns into Error
+func (n *TypedLeafNode[S, M]) UpdateAgent(a *Agent, state interface{}, messages []interface{}) (Status, []interface{}) {
+  typed, err := typedMessages[M](messages)
+  if err != nil {
+    a.fail(err, nil)
+    return Error, messages
+  }
+  status, typed := n.update(typedValue[S](state), typed)
+  return status, untypedMessages(typed)
+}
+
+func (n *TypedLeafNode[S, M]) Clone() Node {
+  c := *n
+  c.Status = Failure
+  return &c
+}
+
+func NewTypedLeafNode[S, M any](update func(state S, messages []M) (Status, []M)) TypedNode[S, M] {
+  n := new(TypedLeafNode[S, M])
+  n.update = update
+  return TypedNode[S, M]{n}
+}
+
+// Convert state to S, nil becomes the zero value
+// Panics when state has another type
+func typedValue[S any](state interface{}) S {
+  if state == nil {
+    var zero S
+    return zero
+  }
+  return state.(S)
+}
+
+// Convert messages to M, leaving out those of another type
+func typedMessages[M any](messages []interface{}) ([]M, error) {
+  typed := make([]M, 0, len(messages))
+  var err error
+  for _, m := range messages {
+    if tm, ok := m.(M); ok {
+      typed = append(typed, tm)
+    } else if err == nil {
+      var zero M
+      err = fmt.Errorf("%w: %T is not %T", ErrMessageType, m, zero)
+    }
+  }
+  return typed, err
+}
+
+func untypedMessages[M any](messages []M) []interface{} {
+  if messages == nil {
+    return nil
+  }
+  untyped := make([]interface{}, len(messages))
+  for idx, m := range messages {
+    untyped[idx] = m
+  }
+  return untyped
+}
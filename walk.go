@@ -0,0 +1,91 @@
+package behaviortree
+
+import "errors"
+
+// Implemented by nodes with children,
+// like those that embed CompositeNode or Decorator
+type Parent interface {
+  ChildNodes() []Node
+}
+
+func (n *CompositeNode) ChildNodes() []Node {
+  return n.Children
+}
+
+func (n *Decorator) ChildNodes() []Node {
+  if n.Child == nil {
+    return nil
+  }
+  return []Node{n.Child}
+}
+
+// Returned by a WalkFunc to skip the children of a node
+var SkipChildren = errors.New("skip children")
+
+// Called by Walk for every node
+// path holds the nodes from the root down to node,
+// and is only valid during the call
+type WalkFunc func(path []Node, node Node) error
+
+// Visit root and its descendants depth first, parents before children
+// A node below several parents is visited once for each
+// Walk stops at the first error fn returns, other than SkipChildren,
+// and returns it
+func Walk(root Node, fn WalkFunc) error {
+  return walk(nil, root, fn)
+}
+
+func walk(path []Node, node Node, fn WalkFunc) error {
+  path = append(path, node)
+  if err := fn(path, node); err == SkipChildren {
+    return nil
+  } else if err != nil {
+    return err
+  }
+  if p, ok := node.(Parent); ok {
+    for _, child := range p.ChildNodes() {
+      if err := walk(path, child, fn); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// Stops Find once a match is found
+var errFound = errors.New("found")
+
+// The first node below root, root included, that matches
+// Nil if there is none
+func Find(root Node, match func(node Node) bool) Node {
+  var found Node
+  Walk(root, func(path []Node, node Node) error {
+    if match(node) {
+      found = node
+      return errFound
+    }
+    return nil
+  })
+  return found
+}
+
+// All nodes of type T below root, root included
+func FindAll[T Node](root Node) []T {
+  var found []T
+  Walk(root, func(path []Node, node Node) error {
+    if n, ok := node.(T); ok {
+      found = append(found, n)
+    }
+    return nil
+  })
+  return found
+}
+
+// The node made from the project node with the given id
+// Nil if there is none
+func FindId(root Node, id string) Node {
+  return Find(root, func(node Node) bool {
+    n, ok := node.(interface{ GetId() string })
+    return ok && n.GetId() == id
+  })
+}